@@ -0,0 +1,243 @@
+package beanpod
+
+import (
+	"context"
+	"time"
+)
+
+// Number of commands sent ahead of reading their responses in a batch. It keeps the responses within the socket buffers, so the server never blocks writing them while the client is still writing commands.
+const batchWindow = 512
+
+// A job to put in a batch.
+type JobSpec struct {
+	Body  []byte
+	Pri   uint32
+	Delay time.Duration
+	TTR   time.Duration
+}
+
+// Outcome of putting one job of a batch.
+type PutResult struct {
+	ID  JobID
+	Err error
+}
+
+// Put jobs into a tube, sending the put commands without waiting for each response. The results are in the order of jobs, and a job the server rejects, for example with ErrJobTooBig, does not stop the others.
+//
+// The error is non-nil if the batch could not be completed, for example because the connection broke. The jobs whose response was not received then carry the same error in their results; it wraps ErrMaybeDelivered if they may have been created.
+func (c *Client) PutBatch(tube string, jobs []JobSpec) ([]PutResult, error) {
+	return c.PutBatchContext(context.Background(), tube, jobs)
+}
+
+// PutBatch with a context. If ctx is done, the jobs without a response may or may not have been created.
+func (c *Client) PutBatchContext(ctx context.Context, tube string, jobs []JobSpec) ([]PutResult, error) {
+	results := make([]PutResult, len(jobs))
+	bodies := make([][]byte, len(jobs))
+	op := &Op{Command: "put", Tube: tube}
+	for i, j := range jobs {
+		bodies[i], results[i].Err = c.seal(ctx, j.Body)
+		op.Bytes += len(bodies[i])
+	}
+	done := make([]bool, len(jobs))
+	err := c.exec(ctx, op, true, func() error {
+		if err := c.use(tube); err != nil {
+			return err
+		}
+		for start := 0; start < len(jobs); start += batchWindow {
+			end := start + batchWindow
+			if end > len(jobs) {
+				end = len(jobs)
+			}
+			for i := start; i < end; i++ {
+				if results[i].Err != nil {
+					continue
+				}
+				j := jobs[i]
+				if err := c.conn.send(bodies[i], "put", j.Pri, j.Delay, j.TTR, len(bodies[i])); err != nil {
+					return maybeDelivered(err)
+				}
+			}
+			if err := c.conn.flush(); err != nil {
+				return maybeDelivered(err)
+			}
+			for i := start; i < end; i++ {
+				if results[i].Err != nil {
+					continue
+				}
+				rp, err := c.conn.recv()
+				if err != nil {
+					return maybeDelivered(err)
+				}
+				results[i].ID, results[i].Err = putReply(rp)
+				done[i] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for i := range results {
+			if !done[i] && results[i].Err == nil {
+				results[i].Err = err
+			}
+		}
+	}
+	return results, err
+}
+
+// Delete jobs, sending the delete commands without waiting for each response. It returns the errors of the jobs that could not be deleted by id, or nil if all were. If the connection breaks, the jobs whose response was not received carry the connection error.
+func (c *Client) DeleteMany(ids []JobID) map[JobID]error {
+	return c.DeleteManyContext(context.Background(), ids)
+}
+
+// DeleteMany with a context.
+func (c *Client) DeleteManyContext(ctx context.Context, ids []JobID) map[JobID]error {
+	return c.many(ctx, ids, "DELETED", "delete")
+}
+
+// Release reserved jobs with the same priority and delay, sending the release commands without waiting for each response. The errors are reported as by DeleteMany.
+func (c *Client) ReleaseMany(ids []JobID, pri JobPriority, delay time.Duration) map[JobID]error {
+	return c.ReleaseManyContext(context.Background(), ids, pri, delay)
+}
+
+// ReleaseMany with a context.
+func (c *Client) ReleaseManyContext(ctx context.Context, ids []JobID, pri JobPriority, delay time.Duration) map[JobID]error {
+	return c.many(ctx, ids, "RELEASED", "release", pri, delay)
+}
+
+// Bury reserved jobs with the same priority, sending the bury commands without waiting for each response. The errors are reported as by DeleteMany.
+func (c *Client) BuryMany(ids []JobID, pri JobPriority) map[JobID]error {
+	return c.BuryManyContext(context.Background(), ids, pri)
+}
+
+// BuryMany with a context.
+func (c *Client) BuryManyContext(ctx context.Context, ids []JobID, pri JobPriority) map[JobID]error {
+	return c.many(ctx, ids, "BURIED", "bury", pri)
+}
+
+// Pipeline a command on each job, with the job id followed by args, whose only successful response is the single word ok.
+func (c *Client) many(ctx context.Context, ids []JobID, ok string, name string, args ...interface{}) map[JobID]error {
+	var uniq []JobID
+	seen := make(map[JobID]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			uniq = append(uniq, id)
+			c.endLease(id)
+		}
+	}
+
+	errs := make(map[JobID]error)
+	done := 0 // number of responses received
+	err := c.exec(ctx, &Op{Command: name}, false, func() error {
+		for start := 0; start < len(uniq); start += batchWindow {
+			end := start + batchWindow
+			if end > len(uniq) {
+				end = len(uniq)
+			}
+			for _, id := range uniq[start:end] {
+				if err := c.conn.send(nil, name, append([]interface{}{id}, args...)...); err != nil {
+					return err
+				}
+			}
+			if err := c.conn.flush(); err != nil {
+				return err
+			}
+			for _, id := range uniq[start:end] {
+				rp, err := c.conn.recv()
+				if err != nil {
+					return err
+				}
+				err = rp.expect(ok, 0)
+				c.settle(id, err)
+				if err != nil {
+					errs[id] = err
+				}
+				done++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, id := range uniq[done:] {
+			errs[id] = err
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Reserve up to n jobs from the tubes. It waits as Reserve does for the first job, then takes the jobs that are ready right away without waiting any longer. ErrTimeout is returned if no job is available before timeout has passed.
+func (c *Client) ReserveN(n int, timeout time.Duration, tubes ...string) ([]*Job, error) {
+	return c.ReserveNContext(context.Background(), n, timeout, tubes...)
+}
+
+// ReserveN with a context.
+func (c *Client) ReserveNContext(ctx context.Context, n int, timeout time.Duration, tubes ...string) (jobs []*Job, err error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if len(tubes) == 0 {
+		tubes = []string{"default"}
+	}
+	op := &Op{Command: "reserve-with-timeout"}
+	err = c.exec(ctx, op, true, func() error {
+		jobs, op.Bytes = nil, 0
+		if err := c.watch(tubes); err != nil {
+			return err
+		}
+		rp, err := c.conn.call(nil, "reserve-with-timeout", timeout)
+		if err != nil {
+			return err
+		}
+		job, err := c.reserved(rp)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+
+		// Pipeline reserves that do not wait, until one times out
+		for len(jobs) < n {
+			k := n - len(jobs)
+			if k > batchWindow {
+				k = batchWindow
+			}
+			for i := 0; i < k; i++ {
+				if err := c.conn.send(nil, "reserve-with-timeout", 0); err != nil {
+					return err
+				}
+			}
+			if err := c.conn.flush(); err != nil {
+				return err
+			}
+			more := true
+			for i := 0; i < k; i++ {
+				rp, err := c.conn.recv()
+				if err != nil {
+					return err
+				}
+				// TIMED_OUT or DEADLINE_SOON ends the batch
+				if job, err := c.reserved(rp); err == nil {
+					jobs = append(jobs, job)
+				} else {
+					more = false
+				}
+			}
+			if !more {
+				break
+			}
+		}
+		for _, job := range jobs {
+			op.Bytes += len(job.Body)
+			if len(tubes) == 1 {
+				job.Tube = tubes[0]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
@@ -0,0 +1,1070 @@
+/*
+In-process beanstalkd server for tests.
+
+The server speaks the beanstalkd text protocol on a random local port and keeps everything in memory. It implements tubes, priorities, delays, TTR expiry, bury and kick, paused tubes and the stats commands, with time following a Clock that tests can control.
+*/
+package beanpodtest
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riobard/go-beanpod"
+)
+
+// Default maximum job size, as in beanstalkd
+const DefaultMaxJobSize = 65535
+
+// Urgent jobs have a priority below this
+const urgentPri = 1024
+
+// A reserved job is about to time out once its time left drops below this
+const deadlineMargin = time.Second
+
+// Maximum length of a command line, as in beanstalkd
+const maxLineLen = 224
+
+// Job states
+const (
+	stateReady    = beanpod.StateReady
+	stateDelayed  = beanpod.StateDelayed
+	stateReserved = beanpod.StateReserved
+	stateBuried   = beanpod.StateBuried
+)
+
+// In-memory beanstalkd server listening on a local port.
+type Server struct {
+	Addr string // address the server listens on, as host:port
+
+	// Source of time. Defaults to the system clock. It must be set before Start.
+	Clock Clock
+
+	// Maximum job size in bytes. Defaults to DefaultMaxJobSize. It must be set before Start.
+	MaxJobSize int
+
+	// Keys added to the responses to stats, stats-tube and stats-job, to stand in for a newer server sending keys the client does not know. It must be set before Start.
+	ExtraStats map[string]string
+
+	l        net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	closed   bool
+	started  time.Time
+	id       string
+	nextID   uint64
+	buries   uint64 // sequence number of burials, ordering buried jobs
+	jobs     map[uint64]*job
+	tubes    map[string]*tube
+	sessions map[*session]bool
+	waiters  []*waiter
+	counts   map[string]int // cumulative counters by stats key
+	stopTick func() bool
+}
+
+type job struct {
+	id       uint64
+	tube     *tube
+	pri      uint32
+	delay    time.Duration
+	ttr      time.Duration
+	body     []byte
+	state    beanpod.JobState
+	created  time.Time
+	deadline time.Time // end of the delay if delayed, end of the TTR if reserved
+	buriedAt uint64
+	owner    *session // session that reserved the job
+	reserves int
+	timeouts int
+	releases int
+	buries   int
+	kicks    int
+}
+
+type tube struct {
+	name         string
+	using        int
+	watching     int
+	waiting      int
+	pause        time.Duration
+	pauseUntil   time.Time
+	totalJobs    int
+	cmdDelete    int
+	cmdPauseTube int
+}
+
+// A connection to the server.
+type session struct {
+	s        *Server
+	nc       net.Conn
+	w        *bufio.Writer
+	use      *tube
+	watch    []*tube
+	reserved map[uint64]*job
+	producer bool
+	worker   bool
+	waiting  bool
+	gone     chan struct{} // closed when the connection closes
+}
+
+// A session blocked in a reserve command.
+type waiter struct {
+	sess     *session
+	deadline time.Time // zero means no timeout
+	ch       chan result
+}
+
+// Outcome of a reserve command: a job or an error response.
+type result struct {
+	job *job
+	err string
+}
+
+// A command read from a connection.
+type command struct {
+	name string
+	args []string
+	body []byte
+	err  string // error response to send instead of running the command
+}
+
+// Start a server with the system clock. The caller should Close it when done.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// Make a server without starting it, so its Clock and MaxJobSize can be set first.
+func NewUnstartedServer() *Server {
+	return &Server{}
+}
+
+// Start listening on a random local port.
+func (s *Server) Start() {
+	if s.l != nil {
+		panic("beanpodtest: server already started")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("beanpodtest: failed to listen: %v", err))
+	}
+	if s.Clock == nil {
+		s.Clock = realClock{}
+	}
+	if s.MaxJobSize <= 0 {
+		s.MaxJobSize = DefaultMaxJobSize
+	}
+	var id [8]byte
+	rand.Read(id[:])
+
+	s.l = l
+	s.Addr = l.Addr().String()
+	s.started = s.Clock.Now()
+	s.id = hex.EncodeToString(id[:])
+	s.jobs = make(map[uint64]*job)
+	s.tubes = make(map[string]*tube)
+	s.sessions = make(map[*session]bool)
+	s.counts = make(map[string]int)
+	s.tube("default")
+
+	s.wg.Add(1)
+	go s.serve()
+}
+
+// Stop listening, close all connections and wait for them to finish.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.l.Close()
+	for sess := range s.sessions {
+		sess.nc.Close()
+	}
+	if s.stopTick != nil {
+		s.stopTick()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		def := s.tube("default")
+		sess := &session{
+			s:        s,
+			nc:       nc,
+			w:        bufio.NewWriter(nc),
+			use:      def,
+			watch:    []*tube{def},
+			reserved: make(map[uint64]*job),
+			gone:     make(chan struct{}),
+		}
+		def.using++
+		def.watching++
+		s.sessions[sess] = true
+		s.counts["total-connections"]++
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go sess.run()
+	}
+}
+
+// Get a tube, creating it if needed. It must be called with s.mu held.
+func (s *Server) tube(name string) *tube {
+	t := s.tubes[name]
+	if t == nil {
+		t = &tube{name: name}
+		s.tubes[name] = t
+	}
+	return t
+}
+
+// Drop a tube once nothing refers to it. It must be called with s.mu held.
+func (s *Server) gc(t *tube) {
+	if t.name == "default" || t.using > 0 || t.watching > 0 || t.waiting > 0 {
+		return
+	}
+	for _, j := range s.jobs {
+		if j.tube == t {
+			return
+		}
+	}
+	delete(s.tubes, t.name)
+}
+
+// Read commands from the connection and hand them to the session's goroutine until the connection closes.
+func (sess *session) read(cmds chan<- command) {
+	defer close(cmds)
+	defer close(sess.gone)
+	r := bufio.NewReader(sess.nc)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := parseCommand(line)
+		if cmd.name == "put" && cmd.err == "" {
+			n, _ := strconv.Atoi(cmd.args[3])
+			if n > sess.s.MaxJobSize {
+				if _, err := io.CopyN(io.Discard, r, int64(n)+2); err != nil {
+					return
+				}
+				cmd.err = "JOB_TOO_BIG"
+			} else {
+				body := make([]byte, n+2)
+				if _, err := io.ReadFull(r, body); err != nil {
+					return
+				}
+				if body[n] != '\r' || body[n+1] != '\n' {
+					cmd.err = "EXPECTED_CRLF"
+				}
+				cmd.body = body[:n]
+			}
+		}
+		cmds <- cmd
+	}
+}
+
+// Number of arguments of each command
+var arity = map[string]int{
+	"put":                  4,
+	"use":                  1,
+	"reserve":              0,
+	"reserve-with-timeout": 1,
+	"reserve-job":          1,
+	"delete":               1,
+	"release":              3,
+	"bury":                 2,
+	"touch":                1,
+	"watch":                1,
+	"ignore":               1,
+	"peek":                 1,
+	"peek-ready":           0,
+	"peek-delayed":         0,
+	"peek-buried":          0,
+	"kick":                 1,
+	"kick-job":             1,
+	"stats-job":            1,
+	"stats-tube":           1,
+	"stats":                0,
+	"list-tubes":           0,
+	"list-tube-used":       0,
+	"list-tubes-watched":   0,
+	"pause-tube":           2,
+	"quit":                 0,
+}
+
+func parseCommand(line string) command {
+	if len(line) > maxLineLen || !strings.HasSuffix(line, "\r\n") {
+		return command{err: "BAD_FORMAT"}
+	}
+	words := strings.Split(line[:len(line)-2], " ")
+	cmd := command{name: words[0], args: words[1:]}
+	n, ok := arity[cmd.name]
+	if !ok {
+		cmd.err = "UNKNOWN_COMMAND"
+	} else if len(cmd.args) != n {
+		cmd.err = "BAD_FORMAT"
+	} else if cmd.name == "put" {
+		for _, a := range cmd.args {
+			if _, err := strconv.ParseUint(a, 10, 32); err != nil {
+				cmd.err = "BAD_FORMAT"
+			}
+		}
+	}
+	return cmd
+}
+
+func (sess *session) run() {
+	s := sess.s
+	defer s.wg.Done()
+
+	cmds := make(chan command)
+	go sess.read(cmds)
+	for cmd := range cmds {
+		if cmd.name == "quit" && cmd.err == "" {
+			break
+		}
+		sess.do(cmd)
+		if sess.w.Flush() != nil {
+			break
+		}
+	}
+	sess.nc.Close()
+	for range cmds {
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sess)
+	for _, j := range sess.reserved {
+		s.ready(j)
+	}
+	sess.use.using--
+	s.gc(sess.use)
+	for _, t := range sess.watch {
+		t.watching--
+		s.gc(t)
+	}
+	s.tick()
+}
+
+func (sess *session) reply(format string, args ...interface{}) {
+	fmt.Fprintf(sess.w, format+"\r\n", args...)
+}
+
+func (sess *session) replyJob(name string, j *job) {
+	sess.reply("%s %d %d", name, j.id, len(j.body))
+	sess.w.Write(j.body)
+	sess.w.WriteString("\r\n")
+}
+
+func (sess *session) replyYAML(yaml string) {
+	sess.reply("OK %d", len(yaml))
+	sess.w.WriteString(yaml)
+	sess.w.WriteString("\r\n")
+}
+
+// Run a command and write its response. A reserve command waits for a job, or until the connection closes.
+func (sess *session) do(cmd command) {
+	s := sess.s
+	if cmd.err != "" {
+		sess.reply(cmd.err)
+		return
+	}
+
+	if cmd.name == "reserve" || cmd.name == "reserve-with-timeout" {
+		w, err := sess.reserve(cmd)
+		if err != "" {
+			sess.reply(err)
+			return
+		}
+		var r result
+		select {
+		case r = <-w.ch:
+		case <-sess.gone:
+			// A job assigned meanwhile is released with the session's other jobs
+			s.mu.Lock()
+			s.cancel(w)
+			s.mu.Unlock()
+			return
+		}
+		if r.err != "" {
+			sess.reply(r.err)
+		} else {
+			sess.replyJob("RESERVED", r.job)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts["cmd-"+cmd.name]++
+	now := s.Clock.Now()
+	switch cmd.name {
+	case "put":
+		pri, _ := strconv.ParseUint(cmd.args[0], 10, 32)
+		delay, _ := strconv.ParseUint(cmd.args[1], 10, 32)
+		ttr, _ := strconv.ParseUint(cmd.args[2], 10, 32)
+		if ttr < 1 {
+			ttr = 1
+		}
+		s.nextID++
+		j := &job{
+			id:      s.nextID,
+			tube:    sess.use,
+			pri:     uint32(pri),
+			delay:   time.Duration(delay) * time.Second,
+			ttr:     time.Duration(ttr) * time.Second,
+			body:    cmd.body,
+			created: now,
+		}
+		s.jobs[j.id] = j
+		j.tube.totalJobs++
+		s.counts["total-jobs"]++
+		sess.producer = true
+		if j.delay > 0 {
+			j.state = stateDelayed
+			j.deadline = now.Add(j.delay)
+		} else {
+			j.state = stateReady
+		}
+		sess.reply("INSERTED %d", j.id)
+
+	case "use":
+		if !validName(cmd.args[0]) {
+			sess.reply("BAD_FORMAT")
+			return
+		}
+		t := s.tube(cmd.args[0])
+		t.using++
+		sess.use.using--
+		s.gc(sess.use)
+		sess.use = t
+		sess.reply("USING %s", t.name)
+
+	case "reserve-job":
+		j := s.job(cmd.args[0])
+		if j == nil || j.state == stateReserved {
+			sess.reply("NOT_FOUND")
+			return
+		}
+		s.reserve(sess, j, now)
+		sess.replyJob("RESERVED", j)
+
+	case "delete":
+		j := s.job(cmd.args[0])
+		if j == nil || (j.state == stateReserved && j.owner != sess) {
+			sess.reply("NOT_FOUND")
+			return
+		}
+		delete(s.jobs, j.id)
+		delete(sess.reserved, j.id)
+		j.tube.cmdDelete++
+		s.gc(j.tube)
+		sess.reply("DELETED")
+
+	case "release":
+		j := s.job(cmd.args[0])
+		pri, err1 := strconv.ParseUint(cmd.args[1], 10, 32)
+		delay, err2 := strconv.ParseUint(cmd.args[2], 10, 32)
+		if err1 != nil || err2 != nil {
+			sess.reply("BAD_FORMAT")
+			return
+		}
+		if j == nil || j.state != stateReserved || j.owner != sess {
+			sess.reply("NOT_FOUND")
+			return
+		}
+		delete(sess.reserved, j.id)
+		j.owner = nil
+		j.pri = uint32(pri)
+		j.delay = time.Duration(delay) * time.Second
+		j.releases++
+		if j.delay > 0 {
+			j.state = stateDelayed
+			j.deadline = now.Add(j.delay)
+		} else {
+			j.state = stateReady
+		}
+		sess.reply("RELEASED")
+
+	case "bury":
+		j := s.job(cmd.args[0])
+		pri, err := strconv.ParseUint(cmd.args[1], 10, 32)
+		if err != nil {
+			sess.reply("BAD_FORMAT")
+			return
+		}
+		if j == nil || j.state != stateReserved || j.owner != sess {
+			sess.reply("NOT_FOUND")
+			return
+		}
+		delete(sess.reserved, j.id)
+		j.pri = uint32(pri)
+		s.bury(j)
+		sess.reply("BURIED")
+
+	case "touch":
+		j := s.job(cmd.args[0])
+		if j == nil || j.state != stateReserved || j.owner != sess {
+			sess.reply("NOT_FOUND")
+			return
+		}
+		j.deadline = now.Add(j.ttr)
+		sess.reply("TOUCHED")
+
+	case "watch":
+		if !validName(cmd.args[0]) {
+			sess.reply("BAD_FORMAT")
+			return
+		}
+		t := s.tube(cmd.args[0])
+		if !sess.watches(t) {
+			sess.watch = append(sess.watch, t)
+			t.watching++
+		}
+		sess.reply("WATCHING %d", len(sess.watch))
+
+	case "ignore":
+		if !validName(cmd.args[0]) {
+			sess.reply("BAD_FORMAT")
+			return
+		}
+		for i, t := range sess.watch {
+			if t.name != cmd.args[0] {
+				continue
+			}
+			if len(sess.watch) == 1 {
+				sess.reply("NOT_IGNORED")
+				return
+			}
+			sess.watch = append(sess.watch[:i:i], sess.watch[i+1:]...)
+			t.watching--
+			s.gc(t)
+			break
+		}
+		sess.reply("WATCHING %d", len(sess.watch))
+
+	case "peek":
+		if j := s.job(cmd.args[0]); j != nil {
+			sess.replyJob("FOUND", j)
+		} else {
+			sess.reply("NOT_FOUND")
+		}
+
+	case "peek-ready", "peek-delayed", "peek-buried":
+		state := beanpod.ParseJobState(strings.TrimPrefix(cmd.name, "peek-"))
+		if j := s.first(sess.use, state); j != nil {
+			sess.replyJob("FOUND", j)
+		} else {
+			sess.reply("NOT_FOUND")
+		}
+
+	case "kick":
+		bound, err := strconv.ParseUint(cmd.args[0], 10, 32)
+		if err != nil {
+			sess.reply("BAD_FORMAT")
+			return
+		}
+		state := stateBuried
+		if s.first(sess.use, stateBuried) == nil {
+			state = stateDelayed
+		}
+		n := 0
+		for ; n < int(bound); n++ {
+			j := s.first(sess.use, state)
+			if j == nil {
+				break
+			}
+			s.kick(j)
+		}
+		sess.reply("KICKED %d", n)
+
+	case "kick-job":
+		j := s.job(cmd.args[0])
+		if j == nil || (j.state != stateBuried && j.state != stateDelayed) {
+			sess.reply("NOT_FOUND")
+			return
+		}
+		s.kick(j)
+		sess.reply("KICKED")
+
+	case "stats-job":
+		j := s.job(cmd.args[0])
+		if j == nil {
+			sess.reply("NOT_FOUND")
+			return
+		}
+		sess.replyYAML(s.jobStats(j, now))
+
+	case "stats-tube":
+		t := s.tubes[cmd.args[0]]
+		if t == nil {
+			sess.reply("NOT_FOUND")
+			return
+		}
+		sess.replyYAML(s.tubeStats(t, now))
+
+	case "stats":
+		sess.replyYAML(s.stats(now))
+
+	case "list-tubes":
+		var names []string
+		for name := range s.tubes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		sess.replyYAML(yamlList(names))
+
+	case "list-tube-used":
+		sess.reply("USING %s", sess.use.name)
+
+	case "list-tubes-watched":
+		var names []string
+		for _, t := range sess.watch {
+			names = append(names, t.name)
+		}
+		sess.replyYAML(yamlList(names))
+
+	case "pause-tube":
+		t := s.tubes[cmd.args[0]]
+		delay, err := strconv.ParseUint(cmd.args[1], 10, 32)
+		if err != nil {
+			sess.reply("BAD_FORMAT")
+			return
+		}
+		if t == nil {
+			sess.reply("NOT_FOUND")
+			return
+		}
+		t.pause = time.Duration(delay) * time.Second
+		t.pauseUntil = now.Add(t.pause)
+		t.cmdPauseTube++
+		sess.reply("PAUSED")
+	}
+	s.tick()
+}
+
+// Register a reserve command, which is answered on the waiter's channel.
+func (sess *session) reserve(cmd command) (*waiter, string) {
+	s := sess.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts["cmd-"+cmd.name]++
+	sess.worker = true
+
+	w := &waiter{sess: sess, ch: make(chan result, 1)}
+	if cmd.name == "reserve-with-timeout" {
+		secs, err := strconv.ParseUint(cmd.args[0], 10, 32)
+		if err != nil {
+			return nil, "BAD_FORMAT"
+		}
+		w.deadline = s.Clock.Now().Add(time.Duration(secs) * time.Second)
+	}
+	s.waiters = append(s.waiters, w)
+	sess.waiting = true
+	for _, t := range sess.watch {
+		t.waiting++
+	}
+	s.tick()
+	return w, ""
+}
+
+// Remove a waiter. It must be called with s.mu held.
+func (s *Server) cancel(w *waiter) {
+	for i, x := range s.waiters {
+		if x == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			break
+		}
+	}
+	if w.sess.waiting {
+		w.sess.waiting = false
+		for _, t := range w.sess.watch {
+			t.waiting--
+		}
+	}
+}
+
+// Answer a waiter. It must be called with s.mu held.
+func (s *Server) answer(w *waiter, r result) {
+	s.cancel(w)
+	w.ch <- r
+}
+
+func (sess *session) watches(t *tube) bool {
+	for _, x := range sess.watch {
+		if x == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Look up a job by the ID in a command argument. It must be called with s.mu held.
+func (s *Server) job(arg string) *job {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return s.jobs[id]
+}
+
+// Job of a tube in a state that comes first: by priority and ID when ready, by deadline when delayed, and by burial when buried. It must be called with s.mu held.
+func (s *Server) first(t *tube, state beanpod.JobState) *job {
+	var best *job
+	for _, j := range s.jobs {
+		if j.tube != t || j.state != state {
+			continue
+		}
+		if best == nil || before(j, best) {
+			best = j
+		}
+	}
+	return best
+}
+
+// Report whether job a comes before job b in the same state.
+func before(a, b *job) bool {
+	switch a.state {
+	case stateDelayed:
+		if !a.deadline.Equal(b.deadline) {
+			return a.deadline.Before(b.deadline)
+		}
+	case stateBuried:
+		return a.buriedAt < b.buriedAt
+	default:
+		if a.pri != b.pri {
+			return a.pri < b.pri
+		}
+	}
+	return a.id < b.id
+}
+
+// Make a job ready. It must be called with s.mu held.
+func (s *Server) ready(j *job) {
+	if j.owner != nil {
+		delete(j.owner.reserved, j.id)
+		j.owner = nil
+	}
+	j.state = stateReady
+	j.deadline = time.Time{}
+}
+
+func (s *Server) reserve(sess *session, j *job, now time.Time) {
+	j.state = stateReserved
+	j.owner = sess
+	j.deadline = now.Add(j.ttr)
+	j.reserves++
+	sess.reserved[j.id] = j
+}
+
+func (s *Server) bury(j *job) {
+	s.buries++
+	j.state = stateBuried
+	j.owner = nil
+	j.deadline = time.Time{}
+	j.buriedAt = s.buries
+	j.buries++
+}
+
+func (s *Server) kick(j *job) {
+	j.kicks++
+	s.ready(j)
+}
+
+// Advance the state of jobs, tubes and waiters to the current time, and schedule the next tick. It must be called with s.mu held.
+func (s *Server) tick() {
+	if s.closed {
+		return
+	}
+	now := s.Clock.Now()
+	for _, j := range s.jobs {
+		if j.deadline.IsZero() || now.Before(j.deadline) {
+			continue
+		}
+		switch j.state {
+		case stateDelayed:
+			s.ready(j)
+		case stateReserved:
+			j.timeouts++
+			s.counts["job-timeouts"]++
+			s.ready(j)
+		}
+	}
+	for _, t := range s.tubes {
+		if !t.pauseUntil.IsZero() && !now.Before(t.pauseUntil) {
+			t.pause = 0
+			t.pauseUntil = time.Time{}
+		}
+	}
+
+	// Serve waiters in the order they came
+	for _, w := range append([]*waiter(nil), s.waiters...) {
+		if soon := w.sess.deadlineSoon(now); soon {
+			s.answer(w, result{err: "DEADLINE_SOON"})
+			continue
+		}
+		var best *job
+		for _, t := range w.sess.watch {
+			if !t.pauseUntil.IsZero() {
+				continue
+			}
+			if j := s.first(t, stateReady); j != nil && (best == nil || before(j, best)) {
+				best = j
+			}
+		}
+		if best != nil {
+			s.reserve(w.sess, best, now)
+			s.answer(w, result{job: best})
+		} else if !w.deadline.IsZero() && !now.Before(w.deadline) {
+			s.answer(w, result{err: "TIMED_OUT"})
+		}
+	}
+
+	// Wake up at the next deadline
+	var next time.Time
+	at := func(t time.Time) {
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	for _, j := range s.jobs {
+		at(j.deadline)
+		if j.state == stateReserved {
+			at(j.deadline.Add(-deadlineMargin))
+		}
+	}
+	for _, t := range s.tubes {
+		at(t.pauseUntil)
+	}
+	for _, w := range s.waiters {
+		at(w.deadline)
+	}
+	if s.stopTick != nil {
+		s.stopTick()
+		s.stopTick = nil
+	}
+	if !next.IsZero() {
+		s.stopTick = s.Clock.AfterFunc(next.Sub(now), func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.tick()
+		})
+	}
+}
+
+// Report whether a job reserved by the session is about to time out.
+func (sess *session) deadlineSoon(now time.Time) bool {
+	for _, j := range sess.reserved {
+		if !now.Before(j.deadline.Add(-deadlineMargin)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check a tube name as beanstalkd does.
+func validName(name string) bool {
+	if name == "" || len(name) > 200 || name[0] == '-' {
+		return false
+	}
+	for _, r := range name {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune("-+/;.$_()", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+func yamlList(l []string) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	for _, s := range l {
+		b.WriteString("- " + s + "\n")
+	}
+	return b.String()
+}
+
+// Format key-value pairs as a YAML dictionary.
+func yamlDict(kv ...interface{}) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	for i := 0; i < len(kv); i += 2 {
+		fmt.Fprintf(&b, "%s: %v\n", kv[i], kv[i+1])
+	}
+	return b.String()
+}
+
+// Lines of ExtraStats to append to a stats response, sorted by key.
+func (s *Server) extraStats() string {
+	keys := make([]string, 0, len(s.ExtraStats))
+	for k := range s.ExtraStats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", k, s.ExtraStats[k])
+	}
+	return b.String()
+}
+
+func (s *Server) jobStats(j *job, now time.Time) string {
+	var left time.Duration
+	if j.state == stateDelayed || j.state == stateReserved {
+		left = j.deadline.Sub(now)
+	}
+	return yamlDict(
+		"id", j.id,
+		"tube", strconv.Quote(j.tube.name),
+		"state", j.state,
+		"pri", j.pri,
+		"age", seconds(now.Sub(j.created)),
+		"delay", seconds(j.delay),
+		"ttr", seconds(j.ttr),
+		"time-left", seconds(left),
+		"file", 0,
+		"reserves", j.reserves,
+		"timeouts", j.timeouts,
+		"releases", j.releases,
+		"buries", j.buries,
+		"kicks", j.kicks,
+	) + s.extraStats()
+}
+
+// Count the jobs in each state, and the urgent ones, in tube t, or in all tubes if t is nil.
+func (s *Server) jobCounts(t *tube) (counts map[beanpod.JobState]int, urgent int) {
+	counts = make(map[beanpod.JobState]int)
+	for _, j := range s.jobs {
+		if t != nil && j.tube != t {
+			continue
+		}
+		counts[j.state]++
+		if j.state == stateReady && j.pri < urgentPri {
+			urgent++
+		}
+	}
+	return counts, urgent
+}
+
+func (s *Server) tubeStats(t *tube, now time.Time) string {
+	counts, urgent := s.jobCounts(t)
+	var left time.Duration
+	if !t.pauseUntil.IsZero() {
+		left = t.pauseUntil.Sub(now)
+	}
+	return yamlDict(
+		"name", strconv.Quote(t.name),
+		"current-jobs-urgent", urgent,
+		"current-jobs-ready", counts[stateReady],
+		"current-jobs-reserved", counts[stateReserved],
+		"current-jobs-delayed", counts[stateDelayed],
+		"current-jobs-buried", counts[stateBuried],
+		"total-jobs", t.totalJobs,
+		"current-using", t.using,
+		"current-watching", t.watching,
+		"current-waiting", t.waiting,
+		"cmd-delete", t.cmdDelete,
+		"cmd-pause-tube", t.cmdPauseTube,
+		"pause", seconds(t.pause),
+		"pause-time-left", seconds(left),
+	) + s.extraStats()
+}
+
+func (s *Server) stats(now time.Time) string {
+	counts, urgent := s.jobCounts(nil)
+	var producers, workers, waiting int
+	for sess := range s.sessions {
+		if sess.producer {
+			producers++
+		}
+		if sess.worker {
+			workers++
+		}
+		if sess.waiting {
+			waiting++
+		}
+	}
+	hostname, _ := os.Hostname()
+	c := s.counts
+	return yamlDict(
+		"current-jobs-urgent", urgent,
+		"current-jobs-ready", counts[stateReady],
+		"current-jobs-reserved", counts[stateReserved],
+		"current-jobs-delayed", counts[stateDelayed],
+		"current-jobs-buried", counts[stateBuried],
+		"cmd-put", c["cmd-put"],
+		"cmd-peek", c["cmd-peek"],
+		"cmd-peek-ready", c["cmd-peek-ready"],
+		"cmd-peek-delayed", c["cmd-peek-delayed"],
+		"cmd-peek-buried", c["cmd-peek-buried"],
+		"cmd-reserve", c["cmd-reserve"],
+		"cmd-reserve-with-timeout", c["cmd-reserve-with-timeout"],
+		"cmd-delete", c["cmd-delete"],
+		"cmd-release", c["cmd-release"],
+		"cmd-use", c["cmd-use"],
+		"cmd-watch", c["cmd-watch"],
+		"cmd-ignore", c["cmd-ignore"],
+		"cmd-bury", c["cmd-bury"],
+		"cmd-kick", c["cmd-kick"],
+		"cmd-touch", c["cmd-touch"],
+		"cmd-stats", c["cmd-stats"],
+		"cmd-stats-job", c["cmd-stats-job"],
+		"cmd-stats-tube", c["cmd-stats-tube"],
+		"cmd-list-tubes", c["cmd-list-tubes"],
+		"cmd-list-tube-used", c["cmd-list-tube-used"],
+		"cmd-list-tubes-watched", c["cmd-list-tubes-watched"],
+		"cmd-pause-tube", c["cmd-pause-tube"],
+		"job-timeouts", c["job-timeouts"],
+		"total-jobs", c["total-jobs"],
+		"max-job-size", s.MaxJobSize,
+		"current-tubes", len(s.tubes),
+		"current-connections", len(s.sessions),
+		"current-producers", producers,
+		"current-workers", workers,
+		"current-waiting", waiting,
+		"total-connections", c["total-connections"],
+		"pid", os.Getpid(),
+		"version", strconv.Quote("1.13+beanpodtest"),
+		"rusage-utime", "0.000000",
+		"rusage-stime", "0.000000",
+		"uptime", seconds(now.Sub(s.started)),
+		"binlog-oldest-index", 0,
+		"binlog-current-index", 0,
+		"binlog-records-migrated", 0,
+		"binlog-records-written", 0,
+		"binlog-max-size", 10485760,
+		"draining", false,
+		"id", s.id,
+		"hostname", strconv.Quote(hostname),
+		"os", strconv.Quote(runtime.GOOS),
+		"platform", strconv.Quote(runtime.GOARCH),
+	) + s.extraStats()
+}
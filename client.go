@@ -1,7 +1,10 @@
 package beanpod
 
 import (
-	"github.com/kr/beanstalk"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
 	"time"
 )
 
@@ -25,9 +28,53 @@ const (
 )
 
 // Beanstalkd client
+//
+// A client is safe for concurrent use, but it owns a single connection, so operations are serialized: a blocked Reserve holds up every other operation on the client. Use a Pool to share a server among many goroutines.
+//
+// The client dials the server on first use and again after the connection breaks. Commands that are safe to repeat, such as stats and peek, are retried on a new connection according to the Retry policy. Put is retried only if the connection broke before the job was sent; otherwise it returns an error wrapping ErrMaybeDelivered. Commands on reserved jobs are never retried, since the server releases the jobs reserved on a connection when it breaks.
+//
+// The client remembers the tube of the last put or peek and the tubes of the last reserve, and sends use, watch and ignore only when these change. A new connection is brought back to the same tubes before its first command.
+//
+// Every operation has a variant taking a context. If the context is done before the server responds, the operation returns ctx.Err() and the connection is closed, since its state is unknown at that point. Jobs reserved by the client are released by the server when the connection closes. The next operation dials a new connection.
 type Client struct {
-	addr string
-	*beanstalk.Conn
+	// Policy for retrying commands after the connection broke. Nil means DefaultRetryPolicy.
+	Retry *RetryPolicy
+
+	// Codec of PutValue and ReserveInto. Nil means JSON.
+	Codec Codec
+
+	// Wrap the bodies of jobs being put in an envelope carrying a Header. Jobs in an envelope are unwrapped when reserved or peeked at whether this is set or not.
+	Envelope bool
+
+	// Name of the client written into envelopes.
+	Producer string
+
+	// Middleware that Put and PutValue go through, the first outermost. PutBatch does not, since its jobs are sent together.
+	PutMiddleware []PutMiddleware
+
+	// Propagates trace context from Put to the consumers of the job, if set. It needs Envelope, since the trace context travels in the header.
+	Propagator Propagator
+
+	// Told about every operation on the server, if set. Its methods are called with the client locked, so they must not use the client.
+	Observer Observer
+
+	// Logger for connections, commands, responses and retries, mostly at debug level. Server errors are logged at info level and broken connections at warn level. Nil means silent.
+	Logger *slog.Logger
+
+	// Report stats keys the client does not know in a *StatsError, to detect a server version the client was not written for.
+	StrictStats bool
+
+	addr    string
+	pool    *Pool      // pool the client returns to on Close, if any
+	mu      sync.Mutex // guards the fields below
+	conn    *conn
+	used    string   // tube to use, restored on new connections; "" means default
+	watched []string // tubes to watch, restored on new connections; nil means default
+
+	returned bool // returned to the pool by Close
+
+	leaseMu sync.Mutex
+	leases  map[JobID]*Lease // leases of reserved jobs by job ID
 }
 
 // Make a beanstalk client to a server address (without connecting)
@@ -36,201 +83,710 @@ func New(addr string) *Client {
 }
 
 // Connect to the server
-func (c *Client) Connect() (err error) {
-	if c.Conn != nil {
+func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// Connect to the server, giving up when ctx is done.
+func (c *Client) ConnectContext(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connect(ctx)
+}
+
+func (c *Client) connect(ctx context.Context) (err error) {
+	if c.conn != nil {
 		return nil
 	}
-	c.Conn, err = beanstalk.Dial("tcp", c.addr)
-	return err
+	c.conn, err = dial(ctx, c.addr)
+	if err != nil {
+		c.logWarn("beanpod: connect failed", "addr", c.addr, "err", err)
+		return err
+	}
+	c.conn.logger = func() *slog.Logger { return c.Logger }
+	c.logDebug("beanpod: connected", "addr", c.addr)
+	return nil
 }
 
+// Close the connection and stop the leases of the client. A client obtained from a Pool is returned to the pool instead and must not be used afterwards; closing it again does nothing.
 func (c *Client) Close() error {
-	if c.Conn == nil {
+	if c.pool != nil {
+		c.mu.Lock()
+		returned := c.returned
+		c.returned = true
+		c.mu.Unlock()
+		if !returned {
+			c.endLeases()
+			c.pool.put(c)
+		}
 		return nil
 	}
-	err := c.Conn.Close()
-	c.Conn = nil
+	return c.close()
+}
+
+func (c *Client) close() error {
+	c.endLeases() // before locking, since a touch in progress needs the lock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	c.conn.send(nil, "quit")
+	c.conn.flush()
+	err := c.conn.close()
+	c.conn = nil
+	c.logDebug("beanpod: disconnected", "addr", c.addr)
+	return err
+}
+
+// Throw away a broken connection.
+func (c *Client) drop() {
+	c.conn.close()
+	c.conn = nil
+}
+
+func (c *Client) logDebug(msg string, args ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Debug(msg, args...)
+	}
+}
+
+func (c *Client) logInfo(msg string, args ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Info(msg, args...)
+	}
+}
+
+func (c *Client) logWarn(msg string, args ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Warn(msg, args...)
+	}
+}
+
+// Report whether the client has a connection holding no reserved jobs. The pool uses it to drop clients whose connection broke, and those that would hand their reservations on to the next user.
+func (c *Client) reusable() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil && len(c.conn.reserved) == 0
+}
+
+// Check that the connection is alive with a cheap round-trip.
+func (c *Client) ping(ctx context.Context) error {
+	return c.exec(ctx, &Op{Command: "list-tube-used"}, true, func() error {
+		rp, err := c.conn.call(nil, "list-tube-used")
+		if err != nil {
+			return err
+		}
+		return rp.expect("USING", 1)
+	})
+}
+
+// Run f on a connection to the server as the operation op, telling the observer if there is one. If f breaks the connection, it is closed, and f is run again on a new connection if it is idempotent. If ctx is done before f succeeds, the connection is closed and ctx.Err() is returned.
+func (c *Client) exec(ctx context.Context, op *Op, idempotent bool, f func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obs := c.Observer
+	if obs == nil {
+		return c.run(ctx, idempotent, f)
+	}
+	obs.Before(op)
+	start := time.Now()
+	err := c.run(ctx, idempotent, f)
+	op.Duration, op.Err = time.Since(start), err
+	obs.After(op)
 	return err
 }
 
-// Reserve and return a job from one of the tubes. If no job is available before time timeout has passed, Reserve returns a ConnError recording ErrTimeout.
-func (c *Client) Reserve(timeout time.Duration, tubes ...string) (JobID, []byte, error) {
-	err := c.Connect()
+// Run f, dialing the server first if needed, and retry it as exec does. It must be called with c.mu held.
+func (c *Client) run(ctx context.Context, idempotent bool, f func() error) error {
+	policy := c.Retry
+	if policy == nil {
+		policy = &DefaultRetryPolicy
+	}
+	for attempt := 0; ; attempt++ {
+		err := c.connect(ctx)
+		if err == nil {
+			stop := c.conn.interruptOn(ctx)
+			err = c.restore()
+			if err == nil {
+				err = f()
+			}
+			stop()
+			if err != nil && ctx.Err() != nil {
+				c.logDebug("beanpod: interrupted, closing connection", "err", ctx.Err())
+				c.drop()
+				return ctx.Err()
+			}
+			if !isConnError(err) {
+				return err
+			}
+			c.logWarn("beanpod: connection broken", "addr", c.addr, "err", err)
+			c.drop()
+			if !idempotent || errors.Is(err, ErrMaybeDelivered) {
+				return err
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt >= policy.Attempts {
+			return err
+		}
+		delay := policy.Backoff.Delay(attempt)
+		c.logInfo("beanpod: reconnecting", "addr", c.addr, "attempt", attempt+1, "delay", delay)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Bring the used and watched tubes of the connection in line with those of the client. It sends nothing unless the connection is new.
+func (c *Client) restore() error {
+	if c.used != "" {
+		if err := c.use(c.used); err != nil {
+			return err
+		}
+	}
+	if c.watched != nil {
+		return c.watch(c.watched)
+	}
+	return nil
+}
+
+// Make tube the one used by put and the peek commands, unless it already is.
+func (c *Client) use(tube string) error {
+	if err := checkName(tube); err != nil {
+		return err
+	}
+	if c.conn.used != tube {
+		rp, err := c.conn.call(nil, "use", tube)
+		if err != nil {
+			return err
+		}
+		if err := rp.expect("USING", 1); err != nil {
+			return err
+		}
+		c.conn.used = tube
+	}
+	c.used = tube
+	return nil
+}
+
+// Watch exactly the given tubes for reserve, watching and ignoring only the tubes that differ from those already watched.
+func (c *Client) watch(tubes []string) error {
+	for _, t := range tubes {
+		if err := checkName(t); err != nil {
+			return err
+		}
+	}
+	// Watch first, since the last watched tube cannot be ignored
+	for _, t := range tubes {
+		if contains(c.conn.watched, t) {
+			continue
+		}
+		rp, err := c.conn.call(nil, "watch", t)
+		if err != nil {
+			return err
+		}
+		if err := rp.expect("WATCHING", 1); err != nil {
+			return err
+		}
+		c.conn.watched = append(c.conn.watched, t)
+	}
+	for _, t := range append([]string(nil), c.conn.watched...) {
+		if contains(tubes, t) {
+			continue
+		}
+		rp, err := c.conn.call(nil, "ignore", t)
+		if err != nil {
+			return err
+		}
+		if err := rp.expect("WATCHING", 1); err != nil {
+			return err
+		}
+		c.conn.watched = remove(c.conn.watched, t)
+	}
+	c.watched = append([]string(nil), tubes...)
+	return nil
+}
+
+func contains(l []string, s string) bool {
+	for _, x := range l {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(l []string, s string) []string {
+	r := l[:0]
+	for _, x := range l {
+		if x != s {
+			r = append(r, x)
+		}
+	}
+	return r
+}
+
+// Send a command whose response is FOUND followed by a job.
+func (c *Client) peek(name string, args ...interface{}) (*Job, error) {
+	rp, err := c.conn.call(nil, name, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.expect("FOUND", 2); err != nil {
+		return nil, err
+	}
+	id, err := rp.id(0)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{ID: id, c: c}
+	job.Header, job.Body = openEnvelope(rp.body)
+	return job, nil
+}
+
+// Parse the response to a reserve command.
+func (c *Client) reserved(rp *reply) (*Job, error) {
+	if err := rp.expect("RESERVED", 2); err != nil {
+		return nil, err
+	}
+	id, err := rp.id(0)
+	if err != nil {
+		return nil, err
+	}
+	c.conn.reserved[id] = true
+	job := &Job{ID: id, c: c}
+	job.Header, job.Body = openEnvelope(rp.body)
+	return job, nil
+}
+
+// Forget a job reserved on the connection once the command on it shows the server no longer holds it for the connection.
+func (c *Client) settle(id JobID, err error) {
+	if err == nil || err == ErrNotFound || err == ErrBuried {
+		delete(c.conn.reserved, id)
+	}
+}
+
+// Send a command whose response is OK followed by a YAML dictionary.
+func (c *Client) stats(name string, args ...interface{}) (map[string]string, error) {
+	rp, err := c.conn.call(nil, name, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.expect("OK", 1); err != nil {
+		return nil, err
+	}
+	return parseDict(rp.body)
+}
+
+// Send a command whose response is OK followed by a YAML list.
+func (c *Client) list(name string, args ...interface{}) ([]string, error) {
+	rp, err := c.conn.call(nil, name, args...)
 	if err != nil {
-		return 0, nil, err
+		return nil, err
 	}
+	if err := rp.expect("OK", 1); err != nil {
+		return nil, err
+	}
+	return parseList(rp.body)
+}
+
+// Send a command whose only successful response is the single word ok.
+func (c *Client) cmd(ok string, name string, args ...interface{}) error {
+	rp, err := c.conn.call(nil, name, args...)
+	if err != nil {
+		return err
+	}
+	return rp.expect(ok, 0)
+}
+
+// Reserve and return a job from one of the tubes. If no job is available before time timeout has passed, Reserve returns ErrTimeout. The tube of the job is set only if there is a single tube, since the server does not tell; see Job.LookupTube.
+func (c *Client) Reserve(timeout time.Duration, tubes ...string) (*Job, error) {
+	return c.ReserveContext(context.Background(), timeout, tubes...)
+}
+
+// Reserve with a context.
+func (c *Client) ReserveContext(ctx context.Context, timeout time.Duration, tubes ...string) (job *Job, err error) {
 	if len(tubes) == 0 {
 		tubes = []string{"default"}
 	}
-	ts := beanstalk.NewTubeSet(c.Conn, tubes...)
-	jid, body, err := ts.Reserve(timeout)
+	op := &Op{Command: "reserve-with-timeout"}
+	err = c.exec(ctx, op, true, func() error {
+		if err := c.watch(tubes); err != nil {
+			return err
+		}
+		rp, err := c.conn.call(nil, "reserve-with-timeout", timeout)
+		if err != nil {
+			return err
+		}
+		if job, err = c.reserved(rp); err != nil {
+			return err
+		}
+		op.ID, op.Bytes = job.ID, len(rp.body)
+		if len(tubes) == 1 {
+			job.Tube = tubes[0]
+		}
+		op.Tube = job.Tube
+		return nil
+	})
 	if err != nil {
-		return 0, nil, unwrap(err)
+		return nil, err
 	}
-	return JobID(jid), body, nil
+	return job, nil
 }
 
-// Put a job into a tube with priority pri and TTR ttr, and returns the id of the newly-created job. If delay is nonzero, the server will wait the given amount of time after returning to the client and before putting the job into the ready queue.
+// Put a job into a tube with priority pri and TTR ttr, and returns the id of the newly-created job. If delay is nonzero, the server will wait the given amount of time after returning to the client and before putting the job into the ready queue. If the server buries the job because it ran out of memory, both the id and ErrBuried are returned.
 func (c *Client) Put(tube string, body []byte, pri uint32, delay, ttr time.Duration) (JobID, error) {
-	err := c.Connect()
+	return c.PutContext(context.Background(), tube, body, pri, delay, ttr)
+}
+
+// Put with a context. If ctx is done after the job was sent, it is unknown whether the server created the job. Header values attached to ctx with WithHeader are written into the envelope if the client uses envelopes.
+func (c *Client) PutContext(ctx context.Context, tube string, body []byte, pri uint32, delay, ttr time.Duration) (JobID, error) {
+	if len(c.PutMiddleware) == 0 {
+		return c.put(ctx, tube, body, pri, delay, ttr)
+	}
+	return ChainPut(c.PutMiddleware...)(c.put)(ctx, tube, body, pri, delay, ttr)
+}
+
+// Put a job past the middleware.
+func (c *Client) put(ctx context.Context, tube string, body []byte, pri uint32, delay, ttr time.Duration) (id JobID, err error) {
+	body, err = c.seal(ctx, body)
 	if err != nil {
 		return 0, err
 	}
-	t := &beanstalk.Tube{c.Conn, tube}
-	id, err := t.Put(body, pri, delay, ttr)
-	if err != nil {
-		return 0, unwrap(err)
+	op := &Op{Command: "put", Tube: tube, Bytes: len(body)}
+	err = c.exec(ctx, op, true, func() error {
+		if err := c.use(tube); err != nil {
+			return err
+		}
+		rp, err := c.conn.call(body, "put", pri, delay, ttr, len(body))
+		if err == nil {
+			id, err = putReply(rp)
+			op.ID = id
+		}
+		if isConnError(err) {
+			return maybeDelivered(err)
+		}
+		return err
+	})
+	return id, err
+}
+
+// Parse the response to put. A BURIED response yields both the id and ErrBuried.
+func putReply(rp *reply) (JobID, error) {
+	if rp.name == "BURIED" && len(rp.args) == 1 {
+		id, err := rp.id(0)
+		if err == nil {
+			err = ErrBuried
+		}
+		return id, err
+	}
+	if err := rp.expect("INSERTED", 1); err != nil {
+		return 0, err
 	}
-	return JobID(id), nil
+	return rp.id(0)
 }
 
 // Put a job with normal priority, no delay, and 180 seconds TTR
 func (c *Client) PutDefault(tube string, body []byte) (JobID, error) {
-	err := c.Connect()
-	if err != nil {
-		return 0, err
-	}
-	return c.Put(tube, body, uint32(PRI_NORMAL), 0, TTR_NORMAL)
+	return c.PutDefaultContext(context.Background(), tube, body)
 }
 
-// Get the statistical information about the server.
-func (c *Client) Stats() (*Stats, error) {
-	err := c.Connect()
+// PutDefault with a context.
+func (c *Client) PutDefaultContext(ctx context.Context, tube string, body []byte) (JobID, error) {
+	return c.PutContext(ctx, tube, body, uint32(PRI_NORMAL), 0, TTR_NORMAL)
+}
+
+// Get the statistical information about the server. If some keys of the response cannot be decoded, the stats are returned with a *StatsError.
+func (c *Client) Stats() (*ServerStats, error) {
+	return c.StatsContext(context.Background())
+}
+
+// Stats with a context.
+func (c *Client) StatsContext(ctx context.Context) (*ServerStats, error) {
+	var m map[string]string
+	err := c.exec(ctx, &Op{Command: "stats"}, true, func() (err error) {
+		m, err = c.stats("stats")
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	m, err := c.Conn.Stats()
-	if err != nil {
-		return nil, unwrap(err)
-	}
-	return &Stats{m}, nil
+	st := &ServerStats{m: m}
+	return st, decodeStats(m, st, c.StrictStats)
 }
 
 // Get the statistical information about a tube.
 func (c *Client) StatsTube(tube string) (*TubeStats, error) {
-	err := c.Connect()
-	if err != nil {
+	return c.StatsTubeContext(context.Background(), tube)
+}
+
+// StatsTube with a context.
+func (c *Client) StatsTubeContext(ctx context.Context, tube string) (*TubeStats, error) {
+	if err := checkName(tube); err != nil {
 		return nil, err
 	}
-	t := &beanstalk.Tube{c.Conn, tube}
-	s, err := t.Stats()
+	var m map[string]string
+	err := c.exec(ctx, &Op{Command: "stats-tube", Tube: tube}, true, func() (err error) {
+		m, err = c.stats("stats-tube", tube)
+		return err
+	})
 	if err != nil {
-		return nil, unwrap(err)
+		return nil, err
 	}
-	return &TubeStats{s}, nil
+	st := &TubeStats{m: m}
+	return st, decodeStats(m, st, c.StrictStats)
 }
 
 // Get the statistical information about a job.
 func (c *Client) StatsJob(id JobID) (*JobStats, error) {
-	err := c.Connect()
+	return c.StatsJobContext(context.Background(), id)
+}
+
+// StatsJob with a context.
+func (c *Client) StatsJobContext(ctx context.Context, id JobID) (*JobStats, error) {
+	var m map[string]string
+	err := c.exec(ctx, &Op{Command: "stats-job", ID: id}, true, func() (err error) {
+		m, err = c.stats("stats-job", id)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	m, err := c.Conn.StatsJob(uint64(id))
-	if err != nil {
-		return nil, unwrap(err)
-	}
-	return &JobStats{m}, nil
+	st := &JobStats{m: m}
+	return st, decodeStats(m, st, c.StrictStats)
 }
 
 // Take up to bound jobs from the holding area and moves them into the ready queue, then returns the number of jobs moved. Jobs will be taken in the order in which they were last buried.
 func (c *Client) Kick(tube string, bound int) (int, error) {
-	err := c.Connect()
-	if err != nil {
-		return 0, err
-	}
-	t := &beanstalk.Tube{c.Conn, tube}
-	n, err := t.Kick(bound)
-	if err != nil {
-		return 0, unwrap(err)
-	}
-	return n, nil
+	return c.KickContext(context.Background(), tube, bound)
+}
+
+// Kick with a context.
+func (c *Client) KickContext(ctx context.Context, tube string, bound int) (n int, err error) {
+	err = c.exec(ctx, &Op{Command: "kick", Tube: tube}, false, func() error {
+		if err := c.use(tube); err != nil {
+			return err
+		}
+		rp, err := c.conn.call(nil, "kick", bound)
+		if err != nil {
+			return err
+		}
+		if err := rp.expect("KICKED", 1); err != nil {
+			return err
+		}
+		k, err := rp.uint(0)
+		n = int(k)
+		return err
+	})
+	return n, err
 }
 
 // Delay any new job being reserved from the tube for a given time.
 func (c *Client) Pause(tube string, dur time.Duration) error {
-	err := c.Connect()
-	if err != nil {
+	return c.PauseContext(context.Background(), tube, dur)
+}
+
+// Pause with a context.
+func (c *Client) PauseContext(ctx context.Context, tube string, dur time.Duration) error {
+	if err := checkName(tube); err != nil {
 		return err
 	}
-	t := &beanstalk.Tube{c.Conn, tube}
-	return unwrap(t.Pause(dur))
+	return c.exec(ctx, &Op{Command: "pause-tube", Tube: tube}, true, func() error {
+		return c.cmd("PAUSED", "pause-tube", tube, dur)
+	})
 }
 
 // Get a copy of the job in the holding area that would be kicked next by Kick.
-func (c *Client) PeekBuried(tube string) (JobID, []byte, error) {
-	err := c.Connect()
-	if err != nil {
-		return 0, nil, err
-	}
-	t := &beanstalk.Tube{c.Conn, tube}
-	jid, body, err := t.PeekBuried()
-	if err != nil {
-		return 0, nil, unwrap(err)
-	}
-	return JobID(jid), body, nil
+func (c *Client) PeekBuried(tube string) (*Job, error) {
+	return c.PeekBuriedContext(context.Background(), tube)
+}
+
+// PeekBuried with a context.
+func (c *Client) PeekBuriedContext(ctx context.Context, tube string) (*Job, error) {
+	return c.peekTube(ctx, tube, "peek-buried")
 }
 
 // Get a copy of the delayed job that is next to be put in t's ready queue.
-func (c *Client) PeekDelayed(tube string) (JobID, []byte, error) {
-	err := c.Connect()
-	if err != nil {
-		return 0, nil, err
-	}
-	t := &beanstalk.Tube{c.Conn, tube}
-	jid, body, err := t.PeekDelayed()
-	if err != nil {
-		return 0, nil, unwrap(err)
-	}
-	return JobID(jid), body, nil
+func (c *Client) PeekDelayed(tube string) (*Job, error) {
+	return c.PeekDelayedContext(context.Background(), tube)
+}
+
+// PeekDelayed with a context.
+func (c *Client) PeekDelayedContext(ctx context.Context, tube string) (*Job, error) {
+	return c.peekTube(ctx, tube, "peek-delayed")
 }
 
 // Get a copy of the job at the front of t's ready queue.
-func (c *Client) PeekReady(tube string) (JobID, []byte, error) {
-	err := c.Connect()
+func (c *Client) PeekReady(tube string) (*Job, error) {
+	return c.PeekReadyContext(context.Background(), tube)
+}
+
+// PeekReady with a context.
+func (c *Client) PeekReadyContext(ctx context.Context, tube string) (*Job, error) {
+	return c.peekTube(ctx, tube, "peek-ready")
+}
+
+// Use tube and send one of the peek commands that operate on the used tube.
+func (c *Client) peekTube(ctx context.Context, tube string, name string) (job *Job, err error) {
+	op := &Op{Command: name, Tube: tube}
+	err = c.exec(ctx, op, true, func() error {
+		if err := c.use(tube); err != nil {
+			return err
+		}
+		job, err = c.peek(name)
+		if err == nil {
+			op.ID, op.Bytes = job.ID, len(job.Body)
+		}
+		return err
+	})
 	if err != nil {
-		return 0, nil, err
+		return nil, err
 	}
-	t := &beanstalk.Tube{c.Conn, tube}
-	jid, body, err := t.PeekReady()
+	job.Tube = tube
+	return job, nil
+}
+
+// Get a copy of the job with the given id, in any state. ErrNotFound is returned if the job does not exist.
+func (c *Client) Peek(id JobID) (*Job, error) {
+	return c.PeekContext(context.Background(), id)
+}
+
+// Peek with a context.
+func (c *Client) PeekContext(ctx context.Context, id JobID) (job *Job, err error) {
+	op := &Op{Command: "peek", ID: id}
+	err = c.exec(ctx, op, true, func() error {
+		job, err = c.peek("peek", id)
+		if err == nil {
+			op.Bytes = len(job.Body)
+		}
+		return err
+	})
 	if err != nil {
-		return 0, nil, unwrap(err)
+		return nil, err
 	}
-	return JobID(jid), body, nil
+	return job, nil
+}
+
+// Move a single buried or delayed job into the ready queue. ErrNotFound is returned if the job does not exist or is in neither state.
+func (c *Client) KickJob(id JobID) error {
+	return c.KickJobContext(context.Background(), id)
+}
+
+// KickJob with a context.
+func (c *Client) KickJobContext(ctx context.Context, id JobID) error {
+	return c.exec(ctx, &Op{Command: "kick-job", ID: id}, false, func() error {
+		return c.cmd("KICKED", "kick-job", id)
+	})
+}
+
+// Get the names of all existing tubes.
+func (c *Client) ListTubes() ([]string, error) {
+	return c.ListTubesContext(context.Background())
+}
+
+// ListTubes with a context.
+func (c *Client) ListTubesContext(ctx context.Context) (tubes []string, err error) {
+	err = c.exec(ctx, &Op{Command: "list-tubes"}, true, func() (err error) {
+		tubes, err = c.list("list-tubes")
+		return err
+	})
+	return tubes, err
+}
+
+// Get the name of the tube currently used by the connection, that is the tube of the last put or peek command.
+func (c *Client) ListTubeUsed() (string, error) {
+	return c.ListTubeUsedContext(context.Background())
+}
+
+// ListTubeUsed with a context.
+func (c *Client) ListTubeUsedContext(ctx context.Context) (tube string, err error) {
+	err = c.exec(ctx, &Op{Command: "list-tube-used"}, true, func() error {
+		rp, err := c.conn.call(nil, "list-tube-used")
+		if err != nil {
+			return err
+		}
+		if err := rp.expect("USING", 1); err != nil {
+			return err
+		}
+		tube = rp.args[0]
+		return nil
+	})
+	return tube, err
+}
+
+// Get the names of the tubes currently watched by the connection, that is the tubes of the last reserve.
+func (c *Client) ListTubesWatched() ([]string, error) {
+	return c.ListTubesWatchedContext(context.Background())
+}
+
+// ListTubesWatched with a context.
+func (c *Client) ListTubesWatchedContext(ctx context.Context) (tubes []string, err error) {
+	err = c.exec(ctx, &Op{Command: "list-tubes-watched"}, true, func() (err error) {
+		tubes, err = c.list("list-tubes-watched")
+		return err
+	})
+	return tubes, err
 }
 
 // Remove the job from the server entirely. It is normally used by the client when the job has successfully run to completion.
 func (c *Client) Delete(id JobID) error {
-	err := c.Connect()
-	if err != nil {
+	return c.DeleteContext(context.Background(), id)
+}
+
+// Delete with a context.
+func (c *Client) DeleteContext(ctx context.Context, id JobID) error {
+	c.endLease(id)
+	return c.exec(ctx, &Op{Command: "delete", ID: id}, false, func() error {
+		err := c.cmd("DELETED", "delete", id)
+		c.settle(id, err)
 		return err
-	}
-	return unwrap(c.Conn.Delete(uint64(id)))
+	})
 }
 
 // Put the job into the "buried" state. Buried jobs are put into a FIFO linked list and will not be touched by the server again until a client kicks them.
 func (c *Client) Bury(id JobID, pri JobPriority) error {
-	err := c.Connect()
-	if err != nil {
+	return c.BuryContext(context.Background(), id, pri)
+}
+
+// Bury with a context.
+func (c *Client) BuryContext(ctx context.Context, id JobID, pri JobPriority) error {
+	c.endLease(id)
+	return c.exec(ctx, &Op{Command: "bury", ID: id}, false, func() error {
+		err := c.cmd("BURIED", "bury", id, pri)
+		c.settle(id, err)
 		return err
-	}
-	return unwrap(c.Conn.Bury(uint64(id), uint32(pri)))
+	})
 }
 
 // Put the reserved job back into the ready queue (and marks its state as ready) to be run by any client. It is normally used when the job fails because of a transitory error.
 func (c *Client) Release(id JobID, pri JobPriority, delay time.Duration) error {
-	err := c.Connect()
-	if err != nil {
+	return c.ReleaseContext(context.Background(), id, pri, delay)
+}
+
+// Release with a context.
+func (c *Client) ReleaseContext(ctx context.Context, id JobID, pri JobPriority, delay time.Duration) error {
+	c.endLease(id)
+	return c.exec(ctx, &Op{Command: "release", ID: id}, false, func() error {
+		err := c.cmd("RELEASED", "release", id, pri, delay)
+		c.settle(id, err)
 		return err
-	}
-	return unwrap(c.Conn.Release(uint64(id), uint32(pri), delay))
+	})
 }
 
 // Request more time to work on the job. This is useful for jobs that potentially take a long time, but you still want the benefits of a TTR pulling a job away from an unresponsive worker. A worker may periodically tell the server that it's still alive and processing a job (e.g. it may do this on DEADLINE_SOON).
 func (c *Client) Touch(id JobID) error {
-	err := c.Connect()
-	if err != nil {
+	return c.TouchContext(context.Background(), id)
+}
+
+// Touch with a context.
+func (c *Client) TouchContext(ctx context.Context, id JobID) error {
+	return c.exec(ctx, &Op{Command: "touch", ID: id}, false, func() error {
+		err := c.cmd("TOUCHED", "touch", id)
+		if err == ErrNotFound {
+			c.settle(id, err)
+		}
 		return err
-	}
-	return unwrap(c.Conn.Touch(uint64(id)))
+	})
 }
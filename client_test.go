@@ -1,47 +1,857 @@
-package beanpod
+package beanpod_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"log"
+	"log/slog"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/riobard/go-beanpod"
+	"github.com/riobard/go-beanpod/beanpodtest"
 )
 
+// Start a fake server with a fake clock, closed when the test ends.
+func fakeServer(t *testing.T) (*beanpodtest.Server, *beanpodtest.FakeClock) {
+	clock := beanpodtest.NewFakeClock(time.Date(2013, 8, 28, 0, 0, 0, 0, time.UTC))
+	srv := beanpodtest.NewUnstartedServer()
+	srv.Clock = clock
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, clock
+}
+
+func newClient(t *testing.T, srv *beanpodtest.Server) *beanpod.Client {
+	c := beanpod.New(srv.Addr)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
 func TestConn(t *testing.T) {
-	c := New("localhost:11300")
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	if _, err := c.PutDefault("default", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
 	st, err := c.Stats()
 	if err != nil {
 		t.Fatal(err)
 	}
-	log.Printf("%s", st)
+	log.Printf("%v", st)
 
-	log.Printf("urgent-jobs = %d", st.UrgentJobs())
-	log.Printf("ready-jobs = %d", st.ReadyJobs())
-	log.Printf("id = %s", st.ID())
-	log.Printf("hostname = %s", st.Hostname())
-	log.Printf("utime = %v", st.RusageUtime())
-	log.Printf("stime = %v", st.RusageStime())
+	log.Printf("urgent-jobs = %d", st.UrgentJobs)
+	log.Printf("ready-jobs = %d", st.ReadyJobs)
+	log.Printf("id = %s", st.ID)
+	log.Printf("hostname = %s", st.Hostname)
+	log.Printf("utime = %v", st.RusageUtime)
+	log.Printf("stime = %v", st.RusageStime)
+	if st.ReadyJobs != 1 || st.PutCmds != 1 {
+		t.Fatalf("ready-jobs = %d, cmd-put = %d", st.ReadyJobs, st.PutCmds)
+	}
+
+	job, err := c.Reserve(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := job.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Printf("%v", s)
+
+	log.Printf("id = %v", s.ID)
+	log.Printf("tube = %v", s.Tube)
+	log.Printf("state = %v", s.State)
+	log.Printf("pri = %v", s.Pri)
+	log.Printf("age = %v", s.Age)
+	log.Printf("time-left = %v", s.TimeLeft)
+	log.Printf("delay = %v", s.Delay)
+	log.Printf("TTR = %v", s.TTR)
+	log.Printf("file = %v", s.File)
+	log.Printf("reserves = %v", s.Reserves)
+	log.Printf("timeouts = %v", s.Timeouts)
+	log.Printf("releases = %v", s.Releases)
+	log.Printf("buries = %v", s.Buries)
+	log.Printf("kicks = %v", s.Kicks)
+	if s.ID != job.ID || s.Tube != "default" || s.State != beanpod.StateReserved || s.TTR != beanpod.TTR_NORMAL {
+		t.Fatalf("unexpected job stats %v", s)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	for _, pri := range []uint32{100, 10, 1000} {
+		if _, err := c.Put("jobs", []byte("x"), pri, 0, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []beanpod.JobPriority
+	for i := 0; i < 3; i++ {
+		job, err := c.Reserve(0, "jobs", "other")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Tube != "" {
+			t.Fatalf("tube = %q before lookup", job.Tube)
+		}
+		if tube, err := job.LookupTube(); err != nil || tube != "jobs" || job.Tube != "jobs" {
+			t.Fatalf("lookup-tube = %q, %v", tube, err)
+		}
+		st, err := job.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, st.Pri)
+	}
+	if got[0] != 10 || got[1] != 100 || got[2] != 1000 {
+		t.Fatalf("reserved in order %v", got)
+	}
+	if _, err := c.Reserve(0, "jobs"); err != beanpod.ErrTimeout {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+}
 
-	jid, _, err := c.Reserve(0)
+func TestDelayAndTTR(t *testing.T) {
+	srv, clock := fakeServer(t)
+	c := newClient(t, srv)
+	id, err := c.Put("jobs", []byte("later"), uint32(beanpod.PRI_NORMAL), 10*time.Second, 5*time.Second)
 	if err != nil {
 		t.Fatal(err)
 	}
-	s, err := c.StatsJob(jid)
+	if job, err := c.PeekDelayed("jobs"); err != nil || job.ID != id {
+		t.Fatalf("peek-delayed = %v, %v", job, err)
+	}
+	if _, err := c.Reserve(0, "jobs"); err != beanpod.ErrTimeout {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+
+	clock.Advance(10 * time.Second)
+	job, err := c.Reserve(0, "jobs")
+	if err != nil || job.ID != id || string(job.Body) != "later" {
+		t.Fatalf("reserve = %v, %v", job, err)
+	}
+
+	// The job times out after its TTR and goes back to the ready queue
+	clock.Advance(5 * time.Second)
+	st, err := c.StatsJob(id)
 	if err != nil {
 		t.Fatal(err)
 	}
-	log.Printf("%s", s)
+	if st.State != beanpod.StateReady || st.Timeouts != 1 {
+		t.Fatalf("state = %v, timeouts = %d", st.State, st.Timeouts)
+	}
+}
 
-	log.Printf("id = %v", s.ID())
-	log.Printf("tube = %v", s.Tube())
-	log.Printf("state = %v", s.State())
-	log.Printf("pri = %v", s.Pri())
-	log.Printf("age = %v", s.Age())
-	log.Printf("time-left = %v", s.TimeLeft())
-	log.Printf("delay = %v", s.Delay())
-	log.Printf("TTR = %v", s.TTR())
-	log.Printf("file = %v", s.File())
-	log.Printf("reserves = %v", s.Reserves())
-	log.Printf("timeouts = %v", s.Timeouts())
-	log.Printf("releases = %v", s.Releases())
-	log.Printf("buries = %v", s.Buries())
-	log.Printf("kicks = %v", s.Kicks())
+func TestBuryKick(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	id, err := c.PutDefault("jobs", []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := c.Reserve(0, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Bury(beanpod.PRI_HIGH); err != nil {
+		t.Fatal(err)
+	}
+	if job, err := c.PeekBuried("jobs"); err != nil || job.ID != id {
+		t.Fatalf("peek-buried = %v, %v", job, err)
+	}
+	if n, err := c.Kick("jobs", 10); err != nil || n != 1 {
+		t.Fatalf("kick = %d, %v", n, err)
+	}
+	if job, err := c.PeekReady("jobs"); err != nil || job.ID != id {
+		t.Fatalf("peek-ready = %v, %v", job, err)
+	}
+	if err := c.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(id); err != beanpod.ErrNotFound {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPause(t *testing.T) {
+	srv, clock := fakeServer(t)
+	c := newClient(t, srv)
+	if _, err := c.PutDefault("jobs", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Pause("jobs", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reserve(0, "jobs"); err != beanpod.ErrTimeout {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+	ts, err := c.StatsTube("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Pause != time.Minute || ts.PauseTubeCmds != 1 {
+		t.Fatalf("pause = %v, cmd-pause-tube = %d", ts.Pause, ts.PauseTubeCmds)
+	}
+	clock.Advance(time.Minute)
+	if _, err := c.Reserve(0, "jobs"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReserveContextCancel(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.ReserveContext(ctx, time.Hour, "jobs")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The client dials a new connection
+	if _, err := c.PutDefault("jobs", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reserve(0, "jobs"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobTooBig(t *testing.T) {
+	srv := beanpodtest.NewUnstartedServer()
+	srv.MaxJobSize = 4
+	srv.Start()
+	defer srv.Close()
+	c := newClient(t, srv)
+	if _, err := c.PutDefault("jobs", []byte("too big")); err != beanpod.ErrJobTooBig {
+		t.Fatalf("err = %v, want ErrJobTooBig", err)
+	}
+	if _, err := c.PutDefault("jobs", []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLease(t *testing.T) {
+	srv := beanpodtest.NewServer()
+	defer srv.Close()
+	c := newClient(t, srv)
+	if _, err := c.Put("jobs", []byte("slow"), 0, 0, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	job, err := c.Reserve(0, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.Lease(context.Background(), job.ID, 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if err := l.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Context().Err() == nil {
+		t.Fatal("lease not stopped by Delete")
+	}
+}
+
+func TestLeaseStop(t *testing.T) {
+	srv := beanpodtest.NewServer()
+	defer srv.Close()
+	c := newClient(t, srv)
+	if _, err := c.Put("jobs", []byte("x"), 0, 0, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	job, err := c.Reserve(0, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stopping the lease, even in the middle of a touch, must not give up the reservation
+	for i := 0; i < 10; i++ {
+		l, err := c.Lease(context.Background(), job.ID, 0.02)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Duration(95+i) * time.Millisecond)
+		l.Stop()
+		if st, err := job.Stats(); err != nil || st.State != beanpod.StateReserved {
+			t.Fatalf("stop %d: stats-job = %v, %v", i, st, err)
+		}
+	}
+}
+
+func TestCloseStopsLeases(t *testing.T) {
+	srv := beanpodtest.NewServer()
+	defer srv.Close()
+	c := beanpod.New(srv.Addr)
+	if _, err := c.Put("jobs", []byte("x"), 0, 0, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	job, err := c.Reserve(0, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.Lease(context.Background(), job.ID, 0.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if l.Context().Err() == nil {
+		t.Fatal("lease not stopped by Close")
+	}
+
+	// A lease still touching would have dialed the server again
+	time.Sleep(500 * time.Millisecond)
+	check := newClient(t, srv)
+	st, err := check.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.CurrentConnections != 1 {
+		t.Fatalf("current-connections = %d, want 1", st.CurrentConnections)
+	}
+}
+
+func TestLeaseLost(t *testing.T) {
+	srv, clock := fakeServer(t)
+	c := newClient(t, srv)
+	if _, err := c.Put("jobs", []byte("x"), 0, 0, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	job, err := c.Reserve(0, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.Lease(context.Background(), job.ID, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+
+	// The job times out on the server before the first touch
+	clock.Advance(time.Second)
+	select {
+	case <-l.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("lease not lost")
+	}
+	if !errors.Is(l.Err(), beanpod.ErrLeaseLost) || !errors.Is(l.Err(), beanpod.ErrNotFound) {
+		t.Fatalf("err = %v", l.Err())
+	}
+}
+
+func TestPool(t *testing.T) {
+	srv, _ := fakeServer(t)
+	p := beanpod.NewPool(srv.Addr)
+	p.MaxActive = 4
+	p.MaxIdle = 4
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.PutDefault("jobs", []byte("x")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	ts, err := p.StatsTube("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.ReadyJobs != 50 {
+		t.Fatalf("ready-jobs = %d", ts.ReadyJobs)
+	}
+	st, err := p.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.TotalConnections > 4 {
+		t.Fatalf("total-connections = %d, want at most 4", st.TotalConnections)
+	}
+	if job, err := p.PeekReady("jobs"); err != nil || job.Delete() != beanpod.ErrUnbound {
+		t.Fatalf("peek-ready = %v, %v", job, err)
+	}
+}
+
+func TestPoolCloseTwice(t *testing.T) {
+	srv, _ := fakeServer(t)
+	p := beanpod.NewPool(srv.Addr)
+	p.MaxActive = 1
+	defer p.Close()
+
+	c, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PutDefault("jobs", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err = p.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestPoolDropsReservations(t *testing.T) {
+	srv, _ := fakeServer(t)
+	p := beanpod.NewPool(srv.Addr)
+	p.MaxActive = 1
+	defer p.Close()
+	if _, err := p.PutDefault("jobs", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reserve(0, "jobs"); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// The reservation went away with the connection, so the job is ready again
+	c, err = p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	job, err := c.Reserve(time.Second, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPeekKickJob(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	id, err := c.PutDefault("jobs", []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := c.Reserve(0, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Bury(beanpod.PRI_HIGH); err != nil {
+		t.Fatal(err)
+	}
+	if job, err := c.Peek(id); err != nil || job.ID != id || string(job.Body) != "x" {
+		t.Fatalf("peek = %v, %v", job, err)
+	}
+	if err := c.KickJob(id); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := c.StatsJob(id); err != nil || st.State != beanpod.StateReady || st.Kicks != 1 {
+		t.Fatalf("stats-job = %v, %v", st, err)
+	}
+	// Ready jobs cannot be kicked
+	if err := c.KickJob(id); err != beanpod.ErrNotFound {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if err := c.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Peek(id); err != beanpod.ErrNotFound {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestListTubes(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	if _, err := c.PutDefault("jobs", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if tube, err := c.ListTubeUsed(); err != nil || tube != "jobs" {
+		t.Fatalf("list-tube-used = %q, %v", tube, err)
+	}
+	tubes, err := c.ListTubes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tubes) != 2 || tubes[0] != "default" || tubes[1] != "jobs" {
+		t.Fatalf("list-tubes = %q", tubes)
+	}
+
+	if _, err := c.Reserve(0, "jobs", "other"); err != nil {
+		t.Fatal(err)
+	}
+	watched, err := c.ListTubesWatched()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watched) != 2 || watched[0] != "jobs" || watched[1] != "other" {
+		t.Fatalf("list-tubes-watched = %q", watched)
+	}
+}
+
+func TestTubeState(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	for i := 0; i < 3; i++ {
+		if _, err := c.PutDefault("jobs", []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Reserve(0, "jobs", "other"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.UseCmds != 1 || st.WatchCmds != 2 || st.IgnoreCmds != 1 {
+		t.Fatalf("cmd-use = %d, cmd-watch = %d, cmd-ignore = %d", st.UseCmds, st.WatchCmds, st.IgnoreCmds)
+	}
+
+	// A cancelled reserve closes the connection; the next one watches the same tubes
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.ReserveContext(ctx, time.Hour, "jobs", "other"); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if tube, err := c.ListTubeUsed(); err != nil || tube != "jobs" {
+		t.Fatalf("list-tube-used = %q, %v", tube, err)
+	}
+	watched, err := c.ListTubesWatched()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watched) != 2 || watched[0] != "jobs" || watched[1] != "other" {
+		t.Fatalf("list-tubes-watched = %q", watched)
+	}
+}
+
+func TestPutBatch(t *testing.T) {
+	srv := beanpodtest.NewUnstartedServer()
+	srv.MaxJobSize = 8
+	srv.Start()
+	defer srv.Close()
+	c := newClient(t, srv)
+
+	jobs := make([]beanpod.JobSpec, 1000)
+	for i := range jobs {
+		jobs[i] = beanpod.JobSpec{Body: []byte("x"), Pri: uint32(i), TTR: time.Minute}
+	}
+	jobs[700].Body = []byte("too big for the server")
+	results, err := c.PutBatch("jobs", jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if i == 700 {
+			if r.Err != beanpod.ErrJobTooBig {
+				t.Fatalf("job %d: err = %v, want ErrJobTooBig", i, r.Err)
+			}
+			continue
+		}
+		if r.Err != nil || r.ID == 0 {
+			t.Fatalf("job %d: %v, %v", i, r.ID, r.Err)
+		}
+	}
+	ts, err := c.StatsTube("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.ReadyJobs != 999 {
+		t.Fatalf("ready-jobs = %d", ts.ReadyJobs)
+	}
+	job, err := c.Reserve(0, "jobs")
+	if err != nil || job.ID != results[0].ID {
+		t.Fatalf("reserve = %v, %v", job, err)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	var ids []beanpod.JobID
+	for i := 0; i < 6; i++ {
+		if _, err := c.PutDefault("jobs", []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		job, err := c.Reserve(0, "jobs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+	if errs := c.ReleaseMany(ids[:2], beanpod.PRI_HIGH, 0); errs != nil {
+		t.Fatal(errs)
+	}
+	if errs := c.BuryMany(ids[2:4], beanpod.PRI_HIGH); errs != nil {
+		t.Fatal(errs)
+	}
+	errs := c.DeleteMany(append(ids[2:], 1000))
+	if len(errs) != 1 || errs[1000] != beanpod.ErrNotFound {
+		t.Fatalf("errs = %v", errs)
+	}
+	ts, err := c.StatsTube("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.ReadyJobs != 2 || ts.BuriedJobs != 0 || ts.ReservedJobs != 0 || ts.DeleteCmds != 4 {
+		t.Fatalf("ready = %d, buried = %d, reserved = %d, cmd-delete = %d", ts.ReadyJobs, ts.BuriedJobs, ts.ReservedJobs, ts.DeleteCmds)
+	}
+}
+
+func TestReserveN(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	for i := 0; i < 5; i++ {
+		tube := "a"
+		if i%2 == 1 {
+			tube = "b"
+		}
+		if _, err := c.PutDefault(tube, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs, err := c.ReserveN(3, 0, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 3 {
+		t.Fatalf("reserved %v", jobs)
+	}
+	for i, want := range []string{"a", "b", "a"} {
+		if tube, err := jobs[i].LookupTube(); err != nil || tube != want {
+			t.Fatalf("job %d: lookup-tube = %q, %v, want %q", i, tube, err, want)
+		}
+	}
+	if jobs, err = c.ReserveN(10, 0, "a", "b"); err != nil || len(jobs) != 2 {
+		t.Fatalf("reserve = %v, %v", jobs, err)
+	}
+	if _, err := c.ReserveN(10, 0, "a", "b"); err != beanpod.ErrTimeout {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+}
+
+func TestJobState(t *testing.T) {
+	for _, s := range []beanpod.JobState{beanpod.StateReady, beanpod.StateDelayed, beanpod.StateReserved, beanpod.StateBuried} {
+		if got := beanpod.ParseJobState(s.String()); got != s {
+			t.Errorf("ParseJobState(%q) = %v", s.String(), got)
+		}
+	}
+	if s := beanpod.ParseJobState("invalid"); s != beanpod.StateUnknown || s.String() != "unknown" {
+		t.Errorf("ParseJobState(invalid) = %v", s)
+	}
+	if beanpod.ParseJobState("unknown") != beanpod.StateUnknown || beanpod.JobState(42).String() != "unknown" {
+		t.Error("out of range state")
+	}
+	if !beanpod.StateBuried.IsTerminal() || beanpod.StateReserved.IsTerminal() {
+		t.Error("IsTerminal")
+	}
+}
+
+func TestStrictStats(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	c.StrictStats = true
+	id, err := c.Put("jobs", []byte("x"), 0, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, err := c.Stats(); err != nil || st.UrgentJobs != 1 || st.ReadyJobs != 1 {
+		t.Fatalf("stats = %v, %v", st, err)
+	}
+	if _, err := c.StatsTube("jobs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.StatsJob(id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStrictStatsExtraKey(t *testing.T) {
+	srv := beanpodtest.NewUnstartedServer()
+	srv.ExtraStats = map[string]string{"next-big-thing": "1"}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	c := newClient(t, srv)
+	c.StrictStats = true
+
+	var serr *beanpod.StatsError
+	if _, err := c.Stats(); !errors.As(err, &serr) || len(serr.Unknown) != 1 || serr.Unknown[0] != "next-big-thing" {
+		t.Fatalf("stats err = %v", err)
+	}
+
+	// The client's own callers only need some keys, so they carry on
+	id, err := c.Put("jobs", []byte("not json"), 7, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, _, err := beanpod.ReserveInto[int](c, 0, "jobs")
+	if !errors.Is(err, beanpod.ErrBadPayload) || job != nil {
+		t.Fatalf("reserve-into = %v, %v", job, err)
+	}
+	st, err := c.StatsJob(id)
+	if !errors.As(err, &serr) || st.State != beanpod.StateBuried || st.Pri != 7 {
+		t.Fatalf("stats-job = %v, %v", st, err)
+	}
+
+	if err := c.KickJob(id); err != nil {
+		t.Fatal(err)
+	}
+	job, err = c.Reserve(0, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.Lease(context.Background(), id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Stop()
+
+	h := beanpod.Recover(3)(func(ctx context.Context, job *beanpod.Job) error {
+		panic("boom")
+	})
+	if err := h(context.Background(), job); beanpod.IsPermanent(err) {
+		t.Fatalf("recover err = %v, want a retry after 2 reserves", err)
+	}
+}
+
+type recorder struct {
+	before, after []beanpod.Op
+}
+
+func (r *recorder) Before(op *beanpod.Op) { r.before = append(r.before, *op) }
+func (r *recorder) After(op *beanpod.Op)  { r.after = append(r.after, *op) }
+
+func TestObserver(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	var r recorder
+	h := beanpod.NewHistogram()
+	c.Observer = &r
+	id, err := c.PutDefault("jobs", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reserve(0, "jobs"); err != nil {
+		t.Fatal(err)
+	}
+	c.Observer = h
+	if err := c.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(id); err != beanpod.ErrNotFound {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+
+	if len(r.before) != 2 || r.before[0].Command != "put" || r.before[0].ID != 0 {
+		t.Fatalf("before = %+v", r.before)
+	}
+	put, res := r.after[0], r.after[1]
+	if put.Command != "put" || put.Tube != "jobs" || put.ID != id || put.Bytes != 5 || put.Duration <= 0 || put.Err != nil {
+		t.Fatalf("put = %+v", put)
+	}
+	if res.Command != "reserve-with-timeout" || res.Tube != "jobs" || res.ID != id || res.Bytes != 5 {
+		t.Fatalf("reserve = %+v", res)
+	}
+
+	st := h.Stats("delete")
+	if st == nil || st.Count != 2 || st.Errors["not found"] != 1 || len(st.Errors) != 1 {
+		t.Fatalf("delete stats = %+v", st)
+	}
+	if q := st.Quantile(0.5); q <= 0 || q > 10*time.Second {
+		t.Fatalf("median = %v", q)
+	}
+	if cmds := h.Commands(); len(cmds) != 1 || cmds[0] != "delete" {
+		t.Fatalf("commands = %v", cmds)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	if _, err := c.Put("jobs", []byte("x"), 7, 0, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// The logger is taken up by a client already connected, and the tube of a repeated put is still logged
+	var buf bytes.Buffer
+	c.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if _, err := c.Put("jobs", []byte("x"), 7, 0, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Reserve(0, "jobs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(1000); err != beanpod.ErrNotFound {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`cmd=put pri=7 delay=0s ttr=1m0s bytes=1 tube=jobs`,
+		`msg="beanpod: recv" reply="INSERTED 2"`,
+		`cmd=watch tube=jobs`,
+		`cmd=reserve-with-timeout timeout=0s tubes=[jobs]`,
+		`level=INFO msg="beanpod: server error" reply=NOT_FOUND err="not found"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in\n%s", want, out)
+		}
+	}
+}
+
+func TestPutMiddleware(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	var trail []string
+	mark := func(name string) beanpod.PutMiddleware {
+		return func(next beanpod.PutFunc) beanpod.PutFunc {
+			return func(ctx context.Context, tube string, body []byte, pri uint32, delay, ttr time.Duration) (beanpod.JobID, error) {
+				trail = append(trail, name)
+				return next(ctx, tube, body, pri, delay, ttr)
+			}
+		}
+	}
+	errEmpty := errors.New("empty body")
+	validate := func(next beanpod.PutFunc) beanpod.PutFunc {
+		return func(ctx context.Context, tube string, body []byte, pri uint32, delay, ttr time.Duration) (beanpod.JobID, error) {
+			if len(body) == 0 {
+				return 0, errEmpty
+			}
+			return next(ctx, tube, append([]byte("checked:"), body...), pri, delay, ttr)
+		}
+	}
+	c.PutMiddleware = []beanpod.PutMiddleware{beanpod.ChainPut(mark("a"), mark("b")), validate}
+
+	if _, err := c.PutDefault("jobs", nil); err != errEmpty {
+		t.Fatalf("err = %v, want %v", err, errEmpty)
+	}
+	if _, err := c.PutDefault("jobs", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if len(trail) != 4 || trail[0] != "a" || trail[1] != "b" {
+		t.Fatalf("trail = %v", trail)
+	}
+	job, err := c.PeekReady("jobs")
+	if err != nil || string(job.Body) != "checked:x" {
+		t.Fatalf("peek-ready = %v, %v", job, err)
+	}
 }
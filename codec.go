@@ -0,0 +1,172 @@
+package beanpod
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBadPayload is wrapped by the errors of ReserveInto for jobs whose body cannot be decoded. Such jobs are buried.
+var ErrBadPayload = errors.New("bad payload")
+
+// Encoding of job bodies.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+
+	// MIME type of the encoded data
+	ContentType() string
+}
+
+// Pre-defined codecs
+var (
+	JSON     Codec = jsonCodec{}
+	Gob      Codec = gobCodec{}
+	Protobuf Codec = protobufCodec{}
+	Msgpack  Codec = msgpackCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) ContentType() string                        { return "application/json" }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+// Protocol buffer messages as generated by gogo/protobuf and older versions of golang/protobuf.
+type protoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type protoUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(protoMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("beanpod: %T has no Marshal method for protobuf", v)
+	}
+	return m.Marshal()
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(protoUnmarshaler)
+	if !ok {
+		return fmt.Errorf("beanpod: %T has no Unmarshal method for protobuf", v)
+	}
+	return m.Unmarshal(data)
+}
+
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+// MessagePack values as generated by tinylib/msgp.
+type msgpackMarshaler interface {
+	MarshalMsg(b []byte) ([]byte, error)
+}
+
+type msgpackUnmarshaler interface {
+	UnmarshalMsg(b []byte) ([]byte, error)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(msgpackMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("beanpod: %T has no MarshalMsg method for msgpack", v)
+	}
+	return m.MarshalMsg(nil)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(msgpackUnmarshaler)
+	if !ok {
+		return fmt.Errorf("beanpod: %T has no UnmarshalMsg method for msgpack", v)
+	}
+	_, err := m.UnmarshalMsg(data)
+	return err
+}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+// Codec of the client, JSON by default.
+func (c *Client) codec() Codec {
+	if c.Codec != nil {
+		return c.Codec
+	}
+	return JSON
+}
+
+// Decode the job body into v with the codec of the client the job is bound to.
+func (j *Job) Decode(v interface{}) error {
+	codec := JSON
+	if j.c != nil {
+		codec = j.c.codec()
+	}
+	return codec.Unmarshal(j.Body, v)
+}
+
+// Encode v with the client's codec and put it into a tube. The codec's content type goes into the envelope header unless ctx carries one. See Client.Put.
+func PutValue[T any](c *Client, tube string, v T, pri uint32, delay, ttr time.Duration) (JobID, error) {
+	return PutValueContext(context.Background(), c, tube, v, pri, delay, ttr)
+}
+
+// PutValue with a context.
+func PutValueContext[T any](ctx context.Context, c *Client, tube string, v T, pri uint32, delay, ttr time.Duration) (JobID, error) {
+	codec := c.codec()
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	if HeaderFromContext(ctx).Get(HeaderContentType) == "" {
+		ctx = WithHeader(ctx, Header{HeaderContentType: codec.ContentType()})
+	}
+	return c.PutContext(ctx, tube, body, pri, delay, ttr)
+}
+
+// Reserve a job and decode its body with the client's codec. A job that cannot be decoded is buried, and an error wrapping ErrBadPayload is returned. See Client.Reserve.
+func ReserveInto[T any](c *Client, timeout time.Duration, tubes ...string) (*Job, T, error) {
+	return ReserveIntoContext[T](context.Background(), c, timeout, tubes...)
+}
+
+// ReserveInto with a context.
+func ReserveIntoContext[T any](ctx context.Context, c *Client, timeout time.Duration, tubes ...string) (*Job, T, error) {
+	var v T
+	job, err := c.ReserveContext(ctx, timeout, tubes...)
+	if err != nil {
+		return nil, v, err
+	}
+	if err := job.Decode(&v); err != nil {
+		// Not cancelled with ctx: interrupting these would close the connection and release the job instead of burying it
+		bctx := context.WithoutCancel(ctx)
+		pri := JobPriority(PRI_NORMAL)
+		if st, serr := job.StatsContext(bctx); statsOK(serr, "pri") {
+			pri = st.Pri
+		}
+		if berr := job.BuryContext(bctx, pri); berr != nil {
+			return nil, v, fmt.Errorf("%w: job %d: %w (bury: %v)", ErrBadPayload, job.ID, err, berr)
+		}
+		return nil, v, fmt.Errorf("%w: job %d: %w", ErrBadPayload, job.ID, err)
+	}
+	return job, v, nil
+}
@@ -0,0 +1,82 @@
+package beanpod_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/riobard/go-beanpod"
+)
+
+type payload struct {
+	Name  string
+	Count int
+}
+
+func TestPutValueReserveInto(t *testing.T) {
+	srv, _ := fakeServer(t)
+	for _, codec := range []beanpod.Codec{beanpod.JSON, beanpod.Gob} {
+		c := newClient(t, srv)
+		c.Codec = codec
+		want := payload{"x", 42}
+		if _, err := beanpod.PutValue(c, "values", want, 0, 0, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		job, got, err := beanpod.ReserveInto[payload](c, 0, "values")
+		if err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		if got != want {
+			t.Fatalf("%s: got %+v, want %+v", codec.ContentType(), got, want)
+		}
+		job.Delete()
+	}
+}
+
+func TestReserveIntoBuriesBadPayload(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	id, err := c.PutDefault("values", []byte("not json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = beanpod.ReserveInto[payload](c, 0, "values")
+	if !errors.Is(err, beanpod.ErrBadPayload) {
+		t.Fatalf("err = %v, want ErrBadPayload", err)
+	}
+	if job, err := c.PeekBuried("values"); err != nil || job.ID != id {
+		t.Fatalf("peek-buried = %v, %v", job, err)
+	}
+}
+
+// Cancel a context once a reserve command returns.
+type cancelOnReserve func()
+
+func (f cancelOnReserve) Before(op *beanpod.Op) {}
+
+func (f cancelOnReserve) After(op *beanpod.Op) {
+	if op.Command == "reserve-with-timeout" {
+		f()
+	}
+}
+
+func TestReserveIntoBuriesCancelled(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	id, err := c.PutDefault("values", []byte("not json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Observer = cancelOnReserve(cancel)
+	_, _, err = beanpod.ReserveIntoContext[payload](ctx, c, 0, "values")
+	if !errors.Is(err, beanpod.ErrBadPayload) {
+		t.Fatalf("err = %v, want ErrBadPayload", err)
+	}
+	c.Observer = nil
+	if job, err := c.PeekBuried("values"); err != nil || job.ID != id {
+		t.Fatalf("peek-buried = %v, %v", job, err)
+	}
+}
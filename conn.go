@@ -0,0 +1,157 @@
+package beanpod
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Connection to a beanstalkd server speaking the text protocol.
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+
+	used    string   // tube used by put and the peek commands
+	watched []string // tubes watched by reserve
+
+	reserved map[JobID]bool // jobs reserved on the connection and not yet deleted, released or buried
+
+	logger func() *slog.Logger // logger of the client, looked up at log time; nil means silent
+}
+
+// Dial a server address. The context only bounds the dialing.
+func dial(ctx context.Context, addr string) (*conn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(nc), nil
+}
+
+func newConn(nc net.Conn) *conn {
+	return &conn{
+		nc:       nc,
+		r:        bufio.NewReader(nc),
+		w:        bufio.NewWriter(nc),
+		used:     "default",
+		watched:  []string{"default"},
+		reserved: make(map[JobID]bool),
+	}
+}
+
+// A deadline in the past which makes blocked I/O return immediately
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Interrupt I/O on the connection when ctx is done. The returned function must be called once the I/O is over; it restores the connection deadline.
+func (c *conn) interruptOn(ctx context.Context) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	// The deadline is set only once ctx is done, so that I/O never times out before ctx.Err() reports why
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			c.nc.SetDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+		c.nc.SetDeadline(time.Time{})
+	}
+}
+
+// Buffer a command without sending it. Commands are sent on the next flush.
+func (c *conn) send(body []byte, name string, args ...interface{}) error {
+	if log := c.log(); log != nil {
+		log.Debug("beanpod: send", c.cmdAttrs(name, args)...)
+	}
+	return writeCmd(c.w, body, name, args...)
+}
+
+// Send all buffered commands.
+func (c *conn) flush() error {
+	return c.w.Flush()
+}
+
+// Read the response to the oldest outstanding command.
+func (c *conn) recv() (*reply, error) {
+	rp, err := readReply(c.r)
+	if log := c.log(); log != nil && err == nil {
+		if e := replyErrors[rp.name]; e != nil && e != ErrTimeout {
+			log.Info("beanpod: server error", "reply", rp.line, "err", e)
+		} else {
+			log.Debug("beanpod: recv", "reply", rp.line)
+		}
+	}
+	return rp, err
+}
+
+// Send a command and read its response.
+func (c *conn) call(body []byte, name string, args ...interface{}) (*reply, error) {
+	if err := c.send(body, name, args...); err != nil {
+		return nil, err
+	}
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	return c.recv()
+}
+
+func (c *conn) close() error {
+	return c.nc.Close()
+}
+
+func (c *conn) log() *slog.Logger {
+	if c.logger == nil {
+		return nil
+	}
+	return c.logger()
+}
+
+// Names of the arguments of commands, for logging
+var argNames = map[string][]string{
+	"put":                  {"pri", "delay", "ttr", "bytes"},
+	"use":                  {"tube"},
+	"watch":                {"tube"},
+	"ignore":               {"tube"},
+	"reserve-with-timeout": {"timeout"},
+	"delete":               {"id"},
+	"release":              {"id", "pri", "delay"},
+	"bury":                 {"id", "pri"},
+	"touch":                {"id"},
+	"peek":                 {"id"},
+	"kick":                 {"bound"},
+	"kick-job":             {"id"},
+	"stats-job":            {"id"},
+	"stats-tube":           {"tube"},
+	"pause-tube":           {"tube", "delay"},
+}
+
+// Log attributes of a command and its arguments, with the tubes it acts on when these were set by an earlier use or watch.
+func (c *conn) cmdAttrs(name string, args []interface{}) []interface{} {
+	attrs := []interface{}{"cmd", name}
+	names := argNames[name]
+	for i, arg := range args {
+		if i < len(names) {
+			attrs = append(attrs, names[i], arg)
+		} else {
+			attrs = append(attrs, "arg", arg)
+		}
+	}
+	switch name {
+	case "put", "peek-ready", "peek-delayed", "peek-buried", "kick":
+		attrs = append(attrs, "tube", c.used)
+	case "reserve", "reserve-with-timeout":
+		attrs = append(attrs, "tubes", c.watched)
+	}
+	return attrs
+}
@@ -0,0 +1,71 @@
+package beanpod
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Change between two snapshots of the server stats.
+type StatsDiff struct {
+	// Time between the snapshots, by the server's uptime. After a restart it is the uptime of the later snapshot.
+	Interval time.Duration
+
+	// Whether the server restarted between the snapshots, in which case the earlier one is ignored and the counters are taken from zero at server start.
+	Reset bool
+
+	// Per-second rate of each cumulative counter by stats key, such as cmd-put. Durations such as rusage-utime are counted in seconds. Rates are zero if the interval is.
+	Rates map[string]float64
+
+	// Change of each current value by stats key, such as current-jobs-ready.
+	Deltas map[string]int64
+}
+
+// Compute the rates of the counters and the changes of the gauges between two snapshots taken from the same server, prev before cur. The server is taken to have restarted if the snapshots have different IDs or cur has a lower uptime; then, as when prev is nil, the counters and gauges are measured from zero at server start.
+func Diff(prev, cur *ServerStats) *StatsDiff {
+	d := &StatsDiff{
+		Rates:  make(map[string]float64),
+		Deltas: make(map[string]int64),
+	}
+	if prev == nil || prev.ID != cur.ID || cur.Uptime < prev.Uptime {
+		prev = &ServerStats{}
+		d.Reset = true
+	}
+	d.Interval = cur.Uptime - prev.Uptime
+
+	pv, cv := reflect.ValueOf(prev).Elem(), reflect.ValueOf(cur).Elem()
+	rt := cv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		tag, ok := rt.Field(i).Tag.Lookup("beanstalk")
+		if !ok {
+			continue
+		}
+		key, opts, _ := strings.Cut(tag, ",")
+		delta := statValue(cv.Field(i)) - statValue(pv.Field(i))
+		switch {
+		case hasOption(opts, "counter"):
+			if d.Interval > 0 {
+				d.Rates[key] = delta / d.Interval.Seconds()
+			} else {
+				d.Rates[key] = 0
+			}
+		case hasOption(opts, "gauge"):
+			d.Deltas[key] = int64(delta)
+		}
+	}
+	return d
+}
+
+// Numeric value of a stats field, with durations in seconds.
+func statValue(f reflect.Value) float64 {
+	if f.Type() == durationType {
+		return time.Duration(f.Int()).Seconds()
+	}
+	switch f.Kind() {
+	case reflect.Int, reflect.Int64:
+		return float64(f.Int())
+	case reflect.Uint32, reflect.Uint64:
+		return float64(f.Uint())
+	}
+	return 0
+}
@@ -1,6 +1,6 @@
 /*
-Beanstalkd client with a friendly interface. 
+Beanstalkd client with a friendly interface.
 
-Current implementation is a wrapper of https://github.com/kr/beanstalk/. 
+The client speaks the beanstalkd text protocol directly and has no dependencies outside the standard library. See https://github.com/beanstalkd/beanstalkd/blob/master/doc/protocol.txt for the protocol.
 */
 package beanpod
@@ -0,0 +1,131 @@
+package beanpod
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrBadHeader is returned by Put for header keys or values that cannot be written into an envelope.
+var ErrBadHeader = errors.New("bad envelope header")
+
+// Metadata attached to a job body in an envelope.
+type Header map[string]string
+
+// Get a header value, or "" if it is not set.
+func (h Header) Get(key string) string {
+	return h[key]
+}
+
+// Set a header value.
+func (h Header) Set(key, value string) {
+	h[key] = value
+}
+
+// Standard header keys
+const (
+	HeaderContentType = "Content-Type" // MIME type of the body
+	HeaderProducer    = "Producer"     // name of the client that put the job
+	HeaderEnqueuedAt  = "Enqueued-At"  // time the job was put, in RFC 3339 format
+	HeaderAttempt     = "Attempt"      // number of times the job was put, set by producers that re-put failed jobs
+)
+
+// Version of the envelope format written by Put
+const envelopeVersion = "1"
+
+// Envelopes start with this prefix followed by the version and CRLF. The NUL byte keeps it from being mistaken for text bodies.
+const envelopeMagic = "\x00beanpod/"
+
+// Wrap a body with header lines as
+//
+//	\x00beanpod/1\r\n
+//	Key: value\r\n
+//	...
+//	\r\n
+//	body
+func sealEnvelope(h Header, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(envelopeMagic + envelopeVersion + "\r\n")
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := h[k]
+		if k == "" || strings.ContainsAny(k, ":\r\n") || strings.ContainsAny(v, "\r\n") {
+			return nil, ErrBadHeader
+		}
+		buf.WriteString(k + ": " + v + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// Unwrap a body in an envelope. Bodies without one, or with an envelope of an unknown version, are returned as they are with a nil header.
+func openEnvelope(data []byte) (Header, []byte) {
+	prefix := []byte(envelopeMagic + envelopeVersion + "\r\n")
+	if !bytes.HasPrefix(data, prefix) {
+		return nil, data
+	}
+	h := make(Header)
+	rest := data[len(prefix):]
+	for {
+		i := bytes.Index(rest, []byte("\r\n"))
+		if i < 0 {
+			return nil, data
+		}
+		line := string(rest[:i])
+		rest = rest[i+2:]
+		if line == "" {
+			return h, rest
+		}
+		k, v, ok := strings.Cut(line, ": ")
+		if !ok {
+			return nil, data
+		}
+		h[k] = v
+	}
+}
+
+type headerKey struct{}
+
+// Attach header values to a context, to be written by Put into the envelope of the job. Values already attached to ctx are kept unless overridden.
+func WithHeader(ctx context.Context, h Header) context.Context {
+	merged := make(Header)
+	for k, v := range HeaderFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range h {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, headerKey{}, merged)
+}
+
+// Header values attached to a context with WithHeader.
+func HeaderFromContext(ctx context.Context) Header {
+	h, _ := ctx.Value(headerKey{}).(Header)
+	return h
+}
+
+// Wrap a body being put in an envelope if the client is set to, with the standard headers, those attached to ctx, and the trace context.
+func (c *Client) seal(ctx context.Context, body []byte) ([]byte, error) {
+	if !c.Envelope {
+		return body, nil
+	}
+	h := Header{HeaderEnqueuedAt: time.Now().UTC().Format(time.RFC3339Nano)}
+	if c.Producer != "" {
+		h[HeaderProducer] = c.Producer
+	}
+	for k, v := range HeaderFromContext(ctx) {
+		h[k] = v
+	}
+	if c.Propagator != nil {
+		c.Propagator.Inject(ctx, h)
+	}
+	return sealEnvelope(h, body)
+}
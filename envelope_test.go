@@ -0,0 +1,55 @@
+package beanpod_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/riobard/go-beanpod"
+)
+
+func TestEnvelope(t *testing.T) {
+	srv, _ := fakeServer(t)
+	producer := newClient(t, srv)
+	producer.Envelope = true
+	producer.Producer = "importer"
+	consumer := newClient(t, srv)
+
+	ctx := beanpod.WithHeader(context.Background(), beanpod.Header{"Trace-Id": "abc"})
+	if _, err := beanpod.PutValueContext(ctx, producer, "jobs", payload{"x", 1}, 0, 0, beanpod.TTR_NORMAL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consumer.PutDefault("jobs", []byte("legacy")); err != nil {
+		t.Fatal(err)
+	}
+
+	job, v, err := beanpod.ReserveInto[payload](consumer, 0, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "x" || v.Count != 1 {
+		t.Fatalf("payload = %+v", v)
+	}
+	h := job.Header
+	if h.Get("Trace-Id") != "abc" || h.Get(beanpod.HeaderProducer) != "importer" ||
+		h.Get(beanpod.HeaderContentType) != "application/json" || h.Get(beanpod.HeaderEnqueuedAt) == "" {
+		t.Fatalf("header = %v", h)
+	}
+
+	job, err = consumer.Reserve(0, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Header != nil || string(job.Body) != "legacy" {
+		t.Fatalf("legacy job = %v %q", job.Header, job.Body)
+	}
+}
+
+func TestEnvelopeBadHeader(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	c.Envelope = true
+	ctx := beanpod.WithHeader(context.Background(), beanpod.Header{"Bad": "line\r\nbreak"})
+	if _, err := c.PutContext(ctx, "jobs", []byte("x"), 0, 0, beanpod.TTR_NORMAL); err != beanpod.ErrBadHeader {
+		t.Fatalf("err = %v, want ErrBadHeader", err)
+	}
+}
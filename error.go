@@ -1,33 +1,58 @@
 package beanpod
 
 import (
-	"github.com/kr/beanstalk"
+	"errors"
 )
 
+// Errors reported by the server.
 var (
-	ErrBadFormat  = beanstalk.ErrBadFormat
-	ErrBuried     = beanstalk.ErrBuried
-	ErrDeadline   = beanstalk.ErrDeadline
-	ErrDraining   = beanstalk.ErrDraining
-	ErrInternal   = beanstalk.ErrInternal
-	ErrJobTooBig  = beanstalk.ErrJobTooBig
-	ErrNoCRLF     = beanstalk.ErrNoCRLF
-	ErrNotFound   = beanstalk.ErrNotFound
-	ErrNotIgnored = beanstalk.ErrNotIgnored
-	ErrOOM        = beanstalk.ErrOOM
-	ErrTimeout    = beanstalk.ErrTimeout
-	ErrUnknown    = beanstalk.ErrUnknown
-	ErrEmpty      = beanstalk.ErrEmpty
-	ErrBadChar    = beanstalk.ErrBadChar
-	ErrTooLong    = beanstalk.ErrTooLong
+	ErrBadFormat  = errors.New("bad command format")
+	ErrBuried     = errors.New("buried")
+	ErrDeadline   = errors.New("deadline soon")
+	ErrDraining   = errors.New("server in drain mode")
+	ErrInternal   = errors.New("internal error")
+	ErrJobTooBig  = errors.New("job too big")
+	ErrNoCRLF     = errors.New("expected CR LF")
+	ErrNotFound   = errors.New("not found")
+	ErrNotIgnored = errors.New("not ignored")
+	ErrOOM        = errors.New("server is out of memory")
+	ErrTimeout    = errors.New("timeout")
+	ErrUnknown    = errors.New("unknown command")
 )
 
-// Unwrap a beanstalk error into plain erros
-func unwrap(err error) error {
-	if connErr, ok := err.(beanstalk.ConnError); ok {
-		return connErr.Err
-	} else if nameErr, ok := err.(beanstalk.NameError); ok {
-		return nameErr.Err
+// Errors detected on the client side.
+var (
+	ErrEmpty      = errors.New("name is empty")
+	ErrBadChar    = errors.New("name has bad char")
+	ErrTooLong    = errors.New("name is too long")
+	ErrUnexpected = errors.New("unexpected response")
+)
+
+// Map of server error responses to errors
+var replyErrors = map[string]error{
+	"BAD_FORMAT":      ErrBadFormat,
+	"BURIED":          ErrBuried,
+	"DEADLINE_SOON":   ErrDeadline,
+	"DRAINING":        ErrDraining,
+	"INTERNAL_ERROR":  ErrInternal,
+	"JOB_TOO_BIG":     ErrJobTooBig,
+	"EXPECTED_CRLF":   ErrNoCRLF,
+	"NOT_FOUND":       ErrNotFound,
+	"NOT_IGNORED":     ErrNotIgnored,
+	"OUT_OF_MEMORY":   ErrOOM,
+	"TIMED_OUT":       ErrTimeout,
+	"UNKNOWN_COMMAND": ErrUnknown,
+}
+
+// Report whether err leaves the connection unusable, which is anything but an error response from the server or a bad tube name.
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, e := range replyErrors {
+		if errors.Is(err, e) {
+			return false
+		}
 	}
-	return err
+	return !errors.Is(err, ErrEmpty) && !errors.Is(err, ErrBadChar) && !errors.Is(err, ErrTooLong)
 }
@@ -0,0 +1,190 @@
+package beanpod
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exporter serves the server and tube stats in the Prometheus text exposition format.
+//
+// Counters and gauges of the server are exported as <namespace>_<key>, with the dashes of the stats key turned into underscores and counters suffixed with _total, as in beanstalkd_cmd_put_total. Those of tubes are exported as <namespace>_tube_<key> with a tube label. <namespace>_up is 0 if the server could not be scraped.
+type Exporter struct {
+	// Prefix of the metric names. Defaults to beanstalkd.
+	Namespace string
+
+	// Patterns of the tubes to export, as for path.Match. Nil means all tubes.
+	Tubes []string
+
+	// Patterns of the tubes not to export, even if they match Tubes.
+	ExcludeTubes []string
+
+	// Time limit of a scrape. Zero means 10 seconds.
+	Timeout time.Duration
+
+	// Logger for scrape errors. Defaults to the log package's standard logger.
+	ErrorLog *log.Logger
+
+	pool *Pool
+}
+
+// Make an exporter of the stats of the server that pool connects to.
+func NewExporter(pool *Pool) *Exporter {
+	return &Exporter{pool: pool}
+}
+
+// Scrape the server and write the metrics.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	ns := e.Namespace
+	if ns == "" {
+		ns = "beanstalkd"
+	}
+	m := newMetrics()
+	up := 1.0
+	if err := e.scrape(ctx, ns, m); err != nil {
+		e.logf("beanpod: scrape: %v", err)
+		up = 0
+	}
+	m.add(ns+"_up", "gauge", "", up)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writeTo(w)
+}
+
+func (e *Exporter) scrape(ctx context.Context, ns string, m *metrics) error {
+	c, err := e.pool.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	st, err := c.StatsContext(ctx)
+	if !statsOK(err) { // stats with undecodable keys are still exported, with zeros for these keys
+		return err
+	}
+	m.addStats(ns+"_", "", st)
+
+	tubes, err := c.ListTubesContext(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tube := range tubes {
+		if !e.exported(tube) {
+			continue
+		}
+		ts, err := c.StatsTubeContext(ctx, tube)
+		if err == ErrNotFound {
+			continue // the tube went away since it was listed
+		}
+		if !statsOK(err) {
+			return err
+		}
+		m.addStats(ns+"_tube_", `tube="`+escapeLabel(tube)+`"`, ts)
+	}
+	return nil
+}
+
+// Report whether a tube passes the allow and deny lists.
+func (e *Exporter) exported(tube string) bool {
+	if e.Tubes != nil && !matchAny(e.Tubes, tube) {
+		return false
+	}
+	return !matchAny(e.ExcludeTubes, tube)
+}
+
+func (e *Exporter) logf(format string, args ...interface{}) {
+	if e.ErrorLog != nil {
+		e.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// Metric families in the order they were first added.
+type metrics struct {
+	names    []string
+	families map[string]*family
+}
+
+type family struct {
+	typ     string
+	samples []string
+}
+
+func newMetrics() *metrics {
+	return &metrics{families: make(map[string]*family)}
+}
+
+func (m *metrics) add(name, typ, labels string, v float64) {
+	f := m.families[name]
+	if f == nil {
+		f = &family{typ: typ}
+		m.families[name] = f
+		m.names = append(m.names, name)
+	}
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	f.samples = append(f.samples, name+labels+" "+strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// Add the fields of the stats struct v points to that are tagged counter or gauge.
+func (m *metrics) addStats(prefix, labels string, v interface{}) {
+	rv := reflect.ValueOf(v).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		tag, ok := rt.Field(i).Tag.Lookup("beanstalk")
+		if !ok {
+			continue
+		}
+		key, opts, _ := strings.Cut(tag, ",")
+		name := prefix + strings.ReplaceAll(key, "-", "_")
+		switch {
+		case hasOption(opts, "counter"):
+			if rv.Field(i).Type() == durationType {
+				name += "_seconds"
+			}
+			m.add(name+"_total", "counter", labels, statValue(rv.Field(i)))
+		case hasOption(opts, "gauge"):
+			m.add(name, "gauge", labels, statValue(rv.Field(i)))
+		}
+	}
+}
+
+func (m *metrics) writeTo(w http.ResponseWriter) {
+	var buf bytes.Buffer
+	for _, name := range m.names {
+		f := m.families[name]
+		buf.WriteString("# TYPE " + name + " " + f.typ + "\n")
+		for _, s := range f.samples {
+			buf.WriteString(s + "\n")
+		}
+	}
+	w.Write(buf.Bytes())
+}
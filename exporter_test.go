@@ -0,0 +1,58 @@
+package beanpod_test
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riobard/go-beanpod"
+)
+
+func TestExporter(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	for _, tube := range []string{"jobs", "jobs", "mail", "tmp-1"} {
+		if _, err := c.PutDefault(tube, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := beanpod.NewPool(srv.Addr)
+	defer p.Close()
+	e := beanpod.NewExporter(p)
+	e.ExcludeTubes = []string{"tmp-*"}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	b, _ := io.ReadAll(rec.Body)
+	out := string(b)
+
+	for _, want := range []string{
+		"# TYPE beanstalkd_cmd_put_total counter\nbeanstalkd_cmd_put_total 4\n",
+		"# TYPE beanstalkd_current_jobs_ready gauge\nbeanstalkd_current_jobs_ready 4\n",
+		"beanstalkd_rusage_utime_seconds_total ",
+		"# TYPE beanstalkd_tube_current_jobs_ready gauge\n" +
+			"beanstalkd_tube_current_jobs_ready{tube=\"default\"} 0\n" +
+			"beanstalkd_tube_current_jobs_ready{tube=\"jobs\"} 2\n" +
+			"beanstalkd_tube_current_jobs_ready{tube=\"mail\"} 1\n#",
+		"beanstalkd_up 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "tmp-1") {
+		t.Errorf("excluded tube exported:\n%s", out)
+	}
+
+	srv.Close()
+	e.Timeout = 100 * time.Millisecond
+	e.ErrorLog = log.New(io.Discard, "", 0)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if b, _ := io.ReadAll(rec.Body); !strings.Contains(string(b), "beanstalkd_up 0\n") {
+		t.Errorf("up after the server closed:\n%s", b)
+	}
+}
@@ -0,0 +1,113 @@
+package beanpod
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrUnbound is returned by the methods of a Job that is not bound to a client.
+var ErrUnbound = errors.New("job not bound to a client")
+
+// A job reserved from or peeked at the server. Its methods operate on the job through the client it was obtained from; a reserved job can only be deleted, released, buried or touched by the client that reserved it.
+type Job struct {
+	ID     JobID
+	Body   []byte
+	Tube   string // tube the job came from, if known; see LookupTube
+	Header Header // metadata from the job's envelope, or nil if the job had none
+
+	c *Client
+}
+
+// Make a job bound to a client, e.g. for a job ID obtained elsewhere.
+func (c *Client) Job(id JobID) *Job {
+	return &Job{ID: id, c: c}
+}
+
+// Tube of the job, looked up with stats-job if it is not known, as for a job reserved from several tubes. The tube found is kept in j.Tube.
+func (j *Job) LookupTube() (string, error) {
+	return j.LookupTubeContext(context.Background())
+}
+
+// LookupTube with a context.
+func (j *Job) LookupTubeContext(ctx context.Context) (string, error) {
+	if j.Tube != "" {
+		return j.Tube, nil
+	}
+	st, err := j.StatsContext(ctx)
+	if !statsOK(err, "tube") {
+		return "", err
+	}
+	j.Tube = st.Tube
+	return j.Tube, nil
+}
+
+// Client the job is bound to, or nil.
+func (j *Job) Client() *Client {
+	return j.c
+}
+
+// Delete the job. See Client.Delete.
+func (j *Job) Delete() error {
+	return j.DeleteContext(context.Background())
+}
+
+// Delete with a context.
+func (j *Job) DeleteContext(ctx context.Context) error {
+	if j.c == nil {
+		return ErrUnbound
+	}
+	return j.c.DeleteContext(ctx, j.ID)
+}
+
+// Release the job with priority pri after delay. See Client.Release.
+func (j *Job) Release(pri JobPriority, delay time.Duration) error {
+	return j.ReleaseContext(context.Background(), pri, delay)
+}
+
+// Release with a context.
+func (j *Job) ReleaseContext(ctx context.Context, pri JobPriority, delay time.Duration) error {
+	if j.c == nil {
+		return ErrUnbound
+	}
+	return j.c.ReleaseContext(ctx, j.ID, pri, delay)
+}
+
+// Bury the job with priority pri. See Client.Bury.
+func (j *Job) Bury(pri JobPriority) error {
+	return j.BuryContext(context.Background(), pri)
+}
+
+// Bury with a context.
+func (j *Job) BuryContext(ctx context.Context, pri JobPriority) error {
+	if j.c == nil {
+		return ErrUnbound
+	}
+	return j.c.BuryContext(ctx, j.ID, pri)
+}
+
+// Request more time to work on the job. See Client.Touch.
+func (j *Job) Touch() error {
+	return j.TouchContext(context.Background())
+}
+
+// Touch with a context.
+func (j *Job) TouchContext(ctx context.Context) error {
+	if j.c == nil {
+		return ErrUnbound
+	}
+	return j.c.TouchContext(ctx, j.ID)
+}
+
+// Get the statistical information about the job.
+func (j *Job) Stats() (*JobStats, error) {
+	return j.StatsContext(context.Background())
+}
+
+// Stats with a context.
+func (j *Job) StatsContext(ctx context.Context) (*JobStats, error) {
+	if j.c == nil {
+		return nil, ErrUnbound
+	}
+	return j.c.StatsJobContext(ctx, j.ID)
+}
@@ -0,0 +1,138 @@
+package beanpod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrLeaseLost is the cause of a lease's context being cancelled because touching the job failed. The job is no longer reserved by the client and may be reserved by another one.
+var ErrLeaseLost = errors.New("lease lost")
+
+// Fraction of the TTR between touches when none is given
+const defaultLeaseFraction = 0.5
+
+// Shortest time between touches
+const minLeaseInterval = 100 * time.Millisecond
+
+// Lease keeps a reserved job from timing out by touching it in the background, until the job is deleted, released or buried through the same client, or the lease is stopped.
+type Lease struct {
+	ID JobID
+
+	c      *Client
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	once   sync.Once
+	stop   chan struct{} // closed to stop touching
+	done   chan struct{} // closed when touching stopped
+}
+
+// Start touching a reserved job at the given fraction of its TTR, as reported by StatsJob. A fraction outside (0, 1) means one half. The lease's context is derived from ctx and is cancelled with cause ErrLeaseLost if a touch fails.
+func (c *Client) Lease(ctx context.Context, id JobID, fraction float64) (*Lease, error) {
+	st, err := c.StatsJobContext(ctx, id)
+	if !statsOK(err, "ttr") {
+		return nil, err
+	}
+	if fraction <= 0 || fraction >= 1 {
+		fraction = defaultLeaseFraction
+	}
+	interval := time.Duration(float64(st.TTR) * fraction)
+	if interval < minLeaseInterval {
+		interval = minLeaseInterval
+	}
+
+	l := &Lease{
+		ID:   id,
+		c:    c,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	l.ctx, l.cancel = context.WithCancelCause(ctx)
+
+	c.leaseMu.Lock()
+	if c.leases == nil {
+		c.leases = make(map[JobID]*Lease)
+	}
+	if old := c.leases[id]; old != nil {
+		defer old.Stop()
+	}
+	c.leases[id] = l
+	c.leaseMu.Unlock()
+
+	go l.run(interval)
+	return l, nil
+}
+
+// Context that is cancelled when the lease is lost or stopped.
+func (l *Lease) Context() context.Context {
+	return l.ctx
+}
+
+// Error the lease was lost with, or nil.
+func (l *Lease) Err() error {
+	if err := context.Cause(l.ctx); errors.Is(err, ErrLeaseLost) {
+		return err
+	}
+	return nil
+}
+
+// Stop touching the job and cancel the lease's context. It waits for a touch in progress to finish.
+func (l *Lease) Stop() {
+	l.once.Do(func() {
+		close(l.stop)
+	})
+	<-l.done
+	l.cancel(context.Canceled)
+
+	l.c.leaseMu.Lock()
+	if l.c.leases[l.ID] == l {
+		delete(l.c.leases, l.ID)
+	}
+	l.c.leaseMu.Unlock()
+}
+
+func (l *Lease) run(interval time.Duration) {
+	defer close(l.done)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-l.stop:
+			return
+		case <-l.ctx.Done():
+			return
+		}
+		// A touch is not interrupted when the lease stops, since that would close the connection and with it the reservation
+		if err := l.c.Touch(l.ID); err != nil {
+			l.cancel(fmt.Errorf("%w: %w", ErrLeaseLost, err))
+			return
+		}
+	}
+}
+
+// Stop the lease on a job, if any, because the job is leaving the reserved state.
+func (c *Client) endLease(id JobID) {
+	c.leaseMu.Lock()
+	l := c.leases[id]
+	c.leaseMu.Unlock()
+	if l != nil {
+		l.Stop()
+	}
+}
+
+// Stop all leases, because the client is closing. Touching again would redial the server.
+func (c *Client) endLeases() {
+	c.leaseMu.Lock()
+	leases := make([]*Lease, 0, len(c.leases))
+	for _, l := range c.leases {
+		leases = append(leases, l)
+	}
+	c.leaseMu.Unlock()
+	for _, l := range leases {
+		l.Stop()
+	}
+}
@@ -0,0 +1,35 @@
+package beanpod
+
+import (
+	"context"
+	"time"
+)
+
+// Function putting a job, as Client.PutContext.
+type PutFunc func(ctx context.Context, tube string, body []byte, pri uint32, delay, ttr time.Duration) (JobID, error)
+
+// Wrap putting jobs with behavior such as validation or metrics. A middleware calls next to put the job, possibly with changed arguments, or returns without calling it to reject the job.
+type PutMiddleware func(next PutFunc) PutFunc
+
+// Wrap job handlers with behavior such as logging or recovery.
+type HandlerMiddleware func(next Handler) Handler
+
+// Compose put middleware into one; the first is the outermost.
+func ChainPut(mw ...PutMiddleware) PutMiddleware {
+	return func(next PutFunc) PutFunc {
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		return next
+	}
+}
+
+// Compose handler middleware into one; the first is the outermost.
+func ChainHandler(mw ...HandlerMiddleware) HandlerMiddleware {
+	return func(next Handler) Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		return next
+	}
+}
@@ -0,0 +1,171 @@
+package beanpod
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An operation of a client on the server, as seen by an Observer.
+type Op struct {
+	Command string // protocol command, such as put or reserve-with-timeout
+	Tube    string // tube the command is about, if any
+	ID      JobID  // job the command is about, if any; set after the command for put, reserve and peek
+	Bytes   int    // size of the job bodies sent or received
+
+	// Set after the command
+	Duration time.Duration // time taken, including reconnecting and retrying
+	Err      error
+}
+
+// Observer is told about the operations of a client.
+type Observer interface {
+	// Called before the operation is sent.
+	Before(op *Op)
+
+	// Called after the operation completed or failed.
+	After(op *Op)
+}
+
+// Upper bounds of the default histogram buckets
+var DefaultBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram is an Observer recording the latency, traffic and errors of operations by command in memory. It is safe for concurrent use, so it can be shared by the clients of a pool.
+type Histogram struct {
+	buckets []time.Duration
+	mu      sync.Mutex
+	ops     map[string]*OpStats
+}
+
+// Summary of the operations of a command.
+type OpStats struct {
+	Count  int
+	Bytes  int64
+	Total  time.Duration   // sum of the durations
+	Counts []int           // number of operations by bucket, with one more for those slower than the last bucket
+	Errors map[string]int  // number of failed operations by the kind of error, see ErrorKind
+	bounds []time.Duration // upper bounds of the buckets
+}
+
+// Make a histogram with buckets bounded by the given durations, in increasing order. No bounds means DefaultBuckets.
+func NewHistogram(bounds ...time.Duration) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultBuckets
+	}
+	return &Histogram{buckets: bounds, ops: make(map[string]*OpStats)}
+}
+
+// Before does nothing; operations are recorded once they are done.
+func (h *Histogram) Before(op *Op) {}
+
+// Record an operation.
+func (h *Histogram) After(op *Op) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.ops[op.Command]
+	if st == nil {
+		st = &OpStats{
+			Counts: make([]int, len(h.buckets)+1),
+			Errors: make(map[string]int),
+			bounds: h.buckets,
+		}
+		h.ops[op.Command] = st
+	}
+	st.Count++
+	st.Bytes += int64(op.Bytes)
+	st.Total += op.Duration
+	st.Counts[sort.Search(len(h.buckets), func(i int) bool { return op.Duration <= h.buckets[i] })]++
+	if op.Err != nil {
+		st.Errors[ErrorKind(op.Err)]++
+	}
+}
+
+// Commands recorded so far, sorted.
+func (h *Histogram) Commands() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cmds := make([]string, 0, len(h.ops))
+	for cmd := range h.ops {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	return cmds
+}
+
+// Copy of the summary of a command, or nil if none was recorded.
+func (h *Histogram) Stats(command string) *OpStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.ops[command]
+	if st == nil {
+		return nil
+	}
+	cp := *st
+	cp.Counts = append([]int(nil), st.Counts...)
+	cp.Errors = make(map[string]int, len(st.Errors))
+	for k, n := range st.Errors {
+		cp.Errors[k] = n
+	}
+	return &cp
+}
+
+// Upper bound of the bucket holding the q-quantile of the durations, for q in [0, 1]. It is the largest bucket bound for operations slower than that.
+func (s *OpStats) Quantile(q float64) time.Duration {
+	if s.Count == 0 || len(s.bounds) == 0 {
+		return 0
+	}
+	rank := int(q * float64(s.Count))
+	if rank >= s.Count {
+		rank = s.Count - 1
+	}
+	seen := 0
+	for i, n := range s.Counts {
+		seen += n
+		if seen > rank && i < len(s.bounds) {
+			return s.bounds[i]
+		}
+	}
+	return s.bounds[len(s.bounds)-1]
+}
+
+// Average duration of the operations.
+func (s *OpStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// Short name of the kind of an operation error: the message of the server's error response, such as "not found", the message of a context or client error, or "connection" for a broken connection.
+func ErrorKind(err error) string {
+	for _, e := range replyErrors {
+		if errors.Is(err, e) {
+			return e.Error()
+		}
+	}
+	for _, e := range []error{context.Canceled, context.DeadlineExceeded, ErrMaybeDelivered, ErrUnexpected, ErrEmpty, ErrBadChar, ErrTooLong, ErrBadHeader} {
+		if errors.Is(err, e) {
+			return e.Error()
+		}
+	}
+	if isConnError(err) {
+		return "connection"
+	}
+	return "other"
+}
@@ -0,0 +1,372 @@
+package beanpod
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by Pool.Get after the pool is closed.
+var ErrPoolClosed = errors.New("pool closed")
+
+// Pool of clients connected to the same server. A pool is safe for concurrent use.
+//
+// Jobs are tied to the connection that reserved them, so Reserve, Delete, Release, Bury and Touch should be called on a client obtained with Get and kept until the job is done. The pool itself provides the operations that do not depend on the connection. Jobs peeked at through the pool are not bound to a client, so their methods return ErrUnbound.
+type Pool struct {
+	// Make a new client. Defaults to New with the pool address.
+	New func() *Client
+
+	// Maximum number of idle clients kept in the pool. Zero means no idle clients are kept.
+	MaxIdle int
+
+	// Maximum number of clients handed out or idle at a time. Get waits for a client to be returned when the limit is reached. Zero means no limit. It must not be changed after the first Get.
+	MaxActive int
+
+	// Idle clients are closed after this time. Zero means never.
+	IdleTimeout time.Duration
+
+	// Idle clients are checked with a round-trip to the server before being handed out if they have been idle for longer than this. Zero means always; a negative value means never.
+	TestIdle time.Duration
+
+	addr   string
+	mu     sync.Mutex
+	sem    chan struct{} // a slot for each active client when MaxActive > 0
+	idle   []idleClient  // most recently used last
+	closed bool
+}
+
+type idleClient struct {
+	c     *Client
+	since time.Time
+}
+
+// Make a pool of clients to a server address (without connecting)
+func NewPool(addr string) *Pool {
+	return &Pool{
+		addr:        addr,
+		MaxIdle:     2,
+		IdleTimeout: 5 * time.Minute,
+		TestIdle:    10 * time.Second,
+	}
+}
+
+// Get a client from the pool, making one if no idle client is available. Close the client to return it to the pool.
+func (p *Pool) Get(ctx context.Context) (*Client, error) {
+	p.mu.Lock()
+	if p.sem == nil && p.MaxActive > 0 {
+		p.sem = make(chan struct{}, p.MaxActive)
+	}
+	sem := p.sem
+	p.mu.Unlock()
+
+	if sem != nil {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			p.release()
+			return nil, ErrPoolClosed
+		}
+		p.prune()
+		n := len(p.idle)
+		if n == 0 {
+			p.mu.Unlock()
+			break
+		}
+		ic := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		if p.TestIdle >= 0 && time.Since(ic.since) > p.TestIdle {
+			if err := ic.c.ping(ctx); err != nil {
+				ic.c.close()
+				if ctx.Err() != nil {
+					p.release()
+					return nil, ctx.Err()
+				}
+				continue
+			}
+		}
+		ic.c.mu.Lock()
+		ic.c.returned = false
+		ic.c.mu.Unlock()
+		return ic.c, nil
+	}
+
+	var c *Client
+	if p.New != nil {
+		c = p.New()
+	} else {
+		c = New(p.addr)
+	}
+	c.pool = p
+	return c, nil
+}
+
+// Return a client to the pool.
+func (p *Pool) put(c *Client) {
+	p.mu.Lock()
+	if !p.closed && c.reusable() && len(p.idle) < p.MaxIdle {
+		p.idle = append(p.idle, idleClient{c, time.Now()})
+		c = nil
+	}
+	p.mu.Unlock()
+	if c != nil {
+		c.close()
+	}
+	p.release()
+}
+
+// Free the slot of an active client.
+func (p *Pool) release() {
+	if p.sem != nil {
+		<-p.sem
+	}
+}
+
+// Close idle clients that timed out. It must be called with p.mu held.
+func (p *Pool) prune() {
+	if p.IdleTimeout <= 0 {
+		return
+	}
+	i := 0
+	for i < len(p.idle) && time.Since(p.idle[i].since) > p.IdleTimeout {
+		go p.idle[i].c.close()
+		i++
+	}
+	p.idle = append(p.idle[:0], p.idle[i:]...)
+}
+
+// Close the pool and its idle clients. Clients handed out are closed when they are returned.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+	for _, ic := range idle {
+		ic.c.close()
+	}
+	return nil
+}
+
+// Detach a job from the pooled client it was peeked with, which goes back to the pool.
+func unbind(job *Job, err error) (*Job, error) {
+	if job != nil {
+		job.c = nil
+	}
+	return job, err
+}
+
+// Put a job using a client from the pool. See Client.Put.
+func (p *Pool) Put(tube string, body []byte, pri uint32, delay, ttr time.Duration) (JobID, error) {
+	return p.PutContext(context.Background(), tube, body, pri, delay, ttr)
+}
+
+// Put with a context.
+func (p *Pool) PutContext(ctx context.Context, tube string, body []byte, pri uint32, delay, ttr time.Duration) (JobID, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	return c.PutContext(ctx, tube, body, pri, delay, ttr)
+}
+
+// Put a job with normal priority, no delay, and 180 seconds TTR
+func (p *Pool) PutDefault(tube string, body []byte) (JobID, error) {
+	return p.PutDefaultContext(context.Background(), tube, body)
+}
+
+// PutDefault with a context.
+func (p *Pool) PutDefaultContext(ctx context.Context, tube string, body []byte) (JobID, error) {
+	return p.PutContext(ctx, tube, body, uint32(PRI_NORMAL), 0, TTR_NORMAL)
+}
+
+// Get the statistical information about the server.
+func (p *Pool) Stats() (*ServerStats, error) {
+	return p.StatsContext(context.Background())
+}
+
+// Stats with a context.
+func (p *Pool) StatsContext(ctx context.Context) (*ServerStats, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.StatsContext(ctx)
+}
+
+// Get the statistical information about a tube.
+func (p *Pool) StatsTube(tube string) (*TubeStats, error) {
+	return p.StatsTubeContext(context.Background(), tube)
+}
+
+// StatsTube with a context.
+func (p *Pool) StatsTubeContext(ctx context.Context, tube string) (*TubeStats, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.StatsTubeContext(ctx, tube)
+}
+
+// Get the statistical information about a job.
+func (p *Pool) StatsJob(id JobID) (*JobStats, error) {
+	return p.StatsJobContext(context.Background(), id)
+}
+
+// StatsJob with a context.
+func (p *Pool) StatsJobContext(ctx context.Context, id JobID) (*JobStats, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.StatsJobContext(ctx, id)
+}
+
+// Kick up to bound buried jobs in a tube. See Client.Kick.
+func (p *Pool) Kick(tube string, bound int) (int, error) {
+	return p.KickContext(context.Background(), tube, bound)
+}
+
+// Kick with a context.
+func (p *Pool) KickContext(ctx context.Context, tube string, bound int) (int, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	return c.KickContext(ctx, tube, bound)
+}
+
+// Delay any new job being reserved from the tube for a given time.
+func (p *Pool) Pause(tube string, dur time.Duration) error {
+	return p.PauseContext(context.Background(), tube, dur)
+}
+
+// Pause with a context.
+func (p *Pool) PauseContext(ctx context.Context, tube string, dur time.Duration) error {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.PauseContext(ctx, tube, dur)
+}
+
+// Get a copy of the job in the holding area that would be kicked next by Kick.
+func (p *Pool) PeekBuried(tube string) (*Job, error) {
+	return p.PeekBuriedContext(context.Background(), tube)
+}
+
+// PeekBuried with a context.
+func (p *Pool) PeekBuriedContext(ctx context.Context, tube string) (*Job, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return unbind(c.PeekBuriedContext(ctx, tube))
+}
+
+// Get a copy of the delayed job that is next to be put in t's ready queue.
+func (p *Pool) PeekDelayed(tube string) (*Job, error) {
+	return p.PeekDelayedContext(context.Background(), tube)
+}
+
+// PeekDelayed with a context.
+func (p *Pool) PeekDelayedContext(ctx context.Context, tube string) (*Job, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return unbind(c.PeekDelayedContext(ctx, tube))
+}
+
+// Get a copy of the job at the front of t's ready queue.
+func (p *Pool) PeekReady(tube string) (*Job, error) {
+	return p.PeekReadyContext(context.Background(), tube)
+}
+
+// PeekReady with a context.
+func (p *Pool) PeekReadyContext(ctx context.Context, tube string) (*Job, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return unbind(c.PeekReadyContext(ctx, tube))
+}
+
+// Get a copy of the job with the given id. The job is not bound to a client.
+func (p *Pool) Peek(id JobID) (*Job, error) {
+	return p.PeekContext(context.Background(), id)
+}
+
+// Peek with a context.
+func (p *Pool) PeekContext(ctx context.Context, id JobID) (*Job, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return unbind(c.PeekContext(ctx, id))
+}
+
+// Move a single buried or delayed job into the ready queue.
+func (p *Pool) KickJob(id JobID) error {
+	return p.KickJobContext(context.Background(), id)
+}
+
+// KickJob with a context.
+func (p *Pool) KickJobContext(ctx context.Context, id JobID) error {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.KickJobContext(ctx, id)
+}
+
+// Get the names of all existing tubes.
+func (p *Pool) ListTubes() ([]string, error) {
+	return p.ListTubesContext(context.Background())
+}
+
+// ListTubes with a context.
+func (p *Pool) ListTubesContext(ctx context.Context) ([]string, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.ListTubesContext(ctx)
+}
+
+// Put jobs using a client from the pool. See Client.PutBatch.
+func (p *Pool) PutBatch(tube string, jobs []JobSpec) ([]PutResult, error) {
+	return p.PutBatchContext(context.Background(), tube, jobs)
+}
+
+// PutBatch with a context.
+func (p *Pool) PutBatchContext(ctx context.Context, tube string, jobs []JobSpec) ([]PutResult, error) {
+	c, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.PutBatchContext(ctx, tube, jobs)
+}
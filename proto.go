@@ -0,0 +1,199 @@
+package beanpod
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum length of a tube name in bytes
+const maxNameLen = 200
+
+// Characters allowed in tube names besides letters and digits
+const nameChars = "-+/;.$_()"
+
+// Check that name is a valid tube name.
+func checkName(name string) error {
+	if name == "" {
+		return ErrEmpty
+	}
+	if len(name) > maxNameLen {
+		return ErrTooLong
+	}
+	if name[0] == '-' {
+		return ErrBadChar
+	}
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+		if ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') {
+			continue
+		}
+		if strings.IndexByte(nameChars, b) < 0 {
+			return ErrBadChar
+		}
+	}
+	return nil
+}
+
+// Format a command argument. Durations are sent as whole seconds.
+func formatArg(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case time.Duration:
+		return strconv.FormatInt(int64(v/time.Second), 10)
+	case JobID:
+		return strconv.FormatUint(uint64(v), 10)
+	case JobPriority:
+		return strconv.FormatUint(uint64(v), 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Write a command line, followed by the body if it is not nil.
+func writeCmd(w *bufio.Writer, body []byte, name string, args ...interface{}) error {
+	w.WriteString(name)
+	for _, arg := range args {
+		w.WriteByte(' ')
+		w.WriteString(formatArg(arg))
+	}
+	w.WriteString("\r\n")
+	if body != nil {
+		w.Write(body)
+		w.WriteString("\r\n")
+	}
+	// bufio.Writer keeps the first error and returns it from every later call
+	_, err := w.Write(nil)
+	return err
+}
+
+// Response from the server.
+type reply struct {
+	line string   // first line without CRLF
+	name string   // first word of the line, e.g. INSERTED or RESERVED
+	args []string // remaining words of the line
+	body []byte   // data following the line, if any
+}
+
+// Read a response from the server.
+func readReply(r *bufio.Reader) (*reply, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("%w: %q", ErrUnexpected, line)
+	}
+	line = line[:len(line)-2]
+	words := strings.Split(line, " ")
+	rp := &reply{line: line, name: words[0], args: words[1:]}
+
+	// Responses carrying data end with the number of bytes that follow
+	switch rp.name {
+	case "RESERVED", "FOUND":
+		if len(rp.args) != 2 {
+			return nil, fmt.Errorf("%w: %q", ErrUnexpected, line)
+		}
+	case "OK":
+		if len(rp.args) != 1 {
+			return nil, fmt.Errorf("%w: %q", ErrUnexpected, line)
+		}
+	default:
+		return rp, nil
+	}
+	n, err := strconv.Atoi(rp.args[len(rp.args)-1])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%w: %q", ErrUnexpected, line)
+	}
+	body := make([]byte, n+2)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if !bytes.HasSuffix(body, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: body of %q not terminated by CRLF", ErrUnexpected, line)
+	}
+	rp.body = body[:n]
+	return rp, nil
+}
+
+// Check that the response is name followed by n words. Otherwise return the error the response stands for.
+func (rp *reply) expect(name string, n int) error {
+	if rp.name == name && len(rp.args) == n {
+		return nil
+	}
+	if err, ok := replyErrors[rp.name]; ok {
+		return err
+	}
+	return fmt.Errorf("%w: %q", ErrUnexpected, rp.line)
+}
+
+// Parse the i-th word of the response as an unsigned integer.
+func (rp *reply) uint(i int) (uint64, error) {
+	if i >= len(rp.args) {
+		return 0, fmt.Errorf("%w: %q", ErrUnexpected, rp.line)
+	}
+	n, err := strconv.ParseUint(rp.args[i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrUnexpected, rp.line)
+	}
+	return n, nil
+}
+
+// Parse the i-th word of the response as a job ID.
+func (rp *reply) id(i int) (JobID, error) {
+	n, err := rp.uint(i)
+	return JobID(n), err
+}
+
+// Parse a YAML dictionary of scalars as sent by the stats commands.
+func parseDict(body []byte) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, line := range yamlLines(body) {
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			return nil, fmt.Errorf("%w: bad YAML line %q", ErrUnexpected, line)
+		}
+		k, v := line[:i], line[i+2:]
+		if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+			v = v[1 : len(v)-1]
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// Parse a YAML list of scalars as sent by the list-tubes commands.
+func parseList(body []byte) ([]string, error) {
+	var l []string
+	for _, line := range yamlLines(body) {
+		if !strings.HasPrefix(line, "- ") {
+			return nil, fmt.Errorf("%w: bad YAML line %q", ErrUnexpected, line)
+		}
+		l = append(l, line[2:])
+	}
+	return l, nil
+}
+
+// Split a YAML document into non-empty lines, skipping the document marker.
+func yamlLines(body []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || line == "---" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
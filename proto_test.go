@@ -0,0 +1,96 @@
+package beanpod
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteCmd(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeCmd(w, []byte("hello"), "put", PRI_NORMAL, 5*time.Second, TTR_NORMAL, 5)
+	writeCmd(w, nil, "delete", JobID(42))
+	w.Flush()
+	want := "put 2147483648 5 180 5\r\nhello\r\ndelete 42\r\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("INSERTED 7\r\nRESERVED 7 5\r\nhello\r\nOK 14\r\n---\n- default\n\r\nNOT_FOUND\r\n"))
+
+	rp, err := readReply(r)
+	if err != nil || rp.expect("INSERTED", 1) != nil {
+		t.Fatalf("INSERTED: %v %+v", err, rp)
+	}
+	if id, _ := rp.id(0); id != 7 {
+		t.Fatalf("id = %d", id)
+	}
+
+	rp, err = readReply(r)
+	if err != nil || rp.expect("RESERVED", 2) != nil || string(rp.body) != "hello" {
+		t.Fatalf("RESERVED: %v %+v", err, rp)
+	}
+
+	rp, err = readReply(r)
+	if err != nil || rp.expect("OK", 1) != nil {
+		t.Fatalf("OK: %v %+v", err, rp)
+	}
+	l, err := parseList(rp.body)
+	if err != nil || len(l) != 1 || l[0] != "default" {
+		t.Fatalf("list = %v, %v", l, err)
+	}
+
+	rp, err = readReply(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.expect("DELETED", 0); err != ErrNotFound {
+		t.Fatalf("expect = %v, want ErrNotFound", err)
+	}
+}
+
+func TestReadReplyMalformed(t *testing.T) {
+	for _, s := range []string{"INSERTED 1\n", "RESERVED 1\r\n", "FOUND 1 5\r\nhelloXX"} {
+		_, err := readReply(bufio.NewReader(strings.NewReader(s)))
+		if !errors.Is(err, ErrUnexpected) {
+			t.Errorf("%q: err = %v, want ErrUnexpected", s, err)
+		}
+	}
+	rp, _ := readReply(bufio.NewReader(strings.NewReader("WHAT\r\n")))
+	if err := rp.expect("USING", 1); !errors.Is(err, ErrUnexpected) {
+		t.Errorf("err = %v, want ErrUnexpected", err)
+	}
+}
+
+func TestParseDict(t *testing.T) {
+	m, err := parseDict([]byte("---\nid: 1\ntube: \"default\"\nstate: ready\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["id"] != "1" || m["tube"] != "default" || m["state"] != "ready" {
+		t.Fatalf("m = %v", m)
+	}
+}
+
+func TestCheckName(t *testing.T) {
+	cases := map[string]error{
+		"default":                nil,
+		"a-b+c/d;e.f$g_h(i)":     nil,
+		"":                       ErrEmpty,
+		"-x":                     ErrBadChar,
+		"a b":                    ErrBadChar,
+		strings.Repeat("x", 201): ErrTooLong,
+		strings.Repeat("x", 200): nil,
+	}
+	for name, want := range cases {
+		if err := checkName(name); err != want {
+			t.Errorf("checkName(%q) = %v, want %v", name, err, want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package beanpod
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is the error of a handler that panicked.
+type PanicError struct {
+	Value interface{} // value passed to panic
+	Stack []byte      // stack trace of the goroutine at the panic
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Middleware recovering from panics in the handler, which are turned into a *PanicError. With maxReserves zero or less, the error is marked permanent so the worker buries the job right away. Otherwise the job is retried until it has been reserved maxReserves times, as counted by JobStats.Reserves, and then buried; it is buried right away if the count cannot be looked up. The count is looked up only after a panic.
+func Recover(maxReserves int) HandlerMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, job *Job) (err error) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				err = &PanicError{Value: v, Stack: debug.Stack()}
+				if maxReserves <= 0 {
+					err = Permanent(err)
+					return
+				}
+				// Not cancelled with ctx, which is often done by now: interrupting the lookup would close the connection and release the job
+				if st, serr := job.StatsContext(context.WithoutCancel(ctx)); !statsOK(serr, "reserves") || st.Reserves >= maxReserves {
+					err = Permanent(err)
+				}
+			}()
+			return next(ctx, job)
+		}
+	}
+}
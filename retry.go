@@ -0,0 +1,77 @@
+package beanpod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ErrMaybeDelivered is returned by Put when the connection broke after the job was sent, so the server may or may not have created it. Such puts are never retried automatically.
+var ErrMaybeDelivered = errors.New("put may have been delivered")
+
+// Exponential backoff with jitter.
+type Backoff struct {
+	Min    time.Duration // delay before the first retry
+	Max    time.Duration // upper bound of the delay before jitter; zero means no bound
+	Factor float64       // growth of the delay on each retry; values below 1 mean 2
+	Jitter float64       // fraction of the delay added or removed at random, from 0 to 1
+}
+
+// Delay before the n-th retry, counting from zero.
+func (b Backoff) Delay(n int) time.Duration {
+	f := b.Factor
+	if f < 1 {
+		f = 2
+	}
+	d := float64(b.Min) * math.Pow(f, float64(n))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (2*rand.Float64() - 1)
+	}
+	if d < 0 || math.IsInf(d, 0) || math.IsNaN(d) {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+// Policy for redialing the server after the connection broke.
+type RetryPolicy struct {
+	// Number of times a command is retried on a new connection. Zero disables retries, but the client still redials on the next operation.
+	Attempts int
+
+	// Delay between attempts.
+	Backoff Backoff
+}
+
+// Retry policy of clients that do not set one.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts: 3,
+	Backoff: Backoff{
+		Min:    50 * time.Millisecond,
+		Max:    2 * time.Second,
+		Factor: 2,
+		Jitter: 0.2,
+	},
+}
+
+// Wrap the error of a put that broke the connection after the job was sent.
+func maybeDelivered(err error) error {
+	return fmt.Errorf("%w: %w", ErrMaybeDelivered, err)
+}
+
+// Wait for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
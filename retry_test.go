@@ -0,0 +1,117 @@
+package beanpod
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Min: time.Second, Max: 5 * time.Second, Factor: 2}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if d := b.Delay(i); d != w {
+			t.Errorf("Delay(%d) = %v, want %v", i, d, w)
+		}
+	}
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := b.Delay(0); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("Delay(0) with jitter = %v", d)
+		}
+	}
+}
+
+// Serve each connection with the next handler; connections beyond the handlers are closed.
+func scriptedServer(t *testing.T, handlers ...func(r *bufio.Reader, w net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for i := 0; ; i++ {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if i >= len(handlers) {
+				nc.Close()
+				continue
+			}
+			go func(h func(*bufio.Reader, net.Conn)) {
+				defer nc.Close()
+				h(bufio.NewReader(nc), nc)
+			}(handlers[i])
+		}
+	}()
+	return l.Addr().String()
+}
+
+// Hang up after reading one line.
+func hangUp(r *bufio.Reader, w net.Conn) {
+	r.ReadString('\n')
+}
+
+func TestRetryAfterDisconnect(t *testing.T) {
+	addr := scriptedServer(t, hangUp, func(r *bufio.Reader, w net.Conn) {
+		line, _ := r.ReadString('\n')
+		if strings.HasPrefix(line, "stats") {
+			w.Write([]byte("OK 12\r\n---\npid: 42\n\r\n"))
+		}
+		r.ReadString('\n')
+	})
+	c := New(addr)
+	c.Retry = &RetryPolicy{Attempts: 1}
+	// The script sends a single key, so the stats come with the others missing
+	st, err := c.Stats()
+	var serr *StatsError
+	if !errors.As(err, &serr) {
+		t.Fatal(err)
+	}
+	if st.PID != 42 {
+		t.Fatalf("pid = %d", st.PID)
+	}
+}
+
+func TestPutMaybeDelivered(t *testing.T) {
+	addr := scriptedServer(t, func(r *bufio.Reader, w net.Conn) {
+		r.ReadString('\n')
+		w.Write([]byte("USING jobs\r\n"))
+		r.ReadString('\n')
+	})
+	c := New(addr)
+	c.Retry = &RetryPolicy{Attempts: 3}
+	_, err := c.PutDefault("jobs", []byte("x"))
+	if !errors.Is(err, ErrMaybeDelivered) {
+		t.Fatalf("err = %v, want ErrMaybeDelivered", err)
+	}
+}
+
+func TestPutContextDeadline(t *testing.T) {
+	// The server takes the command but never replies
+	silent := func(r *bufio.Reader, w net.Conn) {
+		r.ReadString('\n')
+		w.Write([]byte("USING jobs\r\n"))
+		io.Copy(io.Discard, r)
+	}
+	var handlers []func(*bufio.Reader, net.Conn)
+	for i := 0; i < 10; i++ {
+		handlers = append(handlers, silent)
+	}
+	addr := scriptedServer(t, handlers...)
+	c := New(addr)
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := c.PutContext(ctx, "jobs", []byte("x"), 0, 0, time.Minute)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("attempt %d: err = %v, want %v", i, err, context.DeadlineExceeded)
+		}
+	}
+}
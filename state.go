@@ -0,0 +1,55 @@
+package beanpod
+
+// State of a job on the server.
+type JobState int
+
+// Job states
+const (
+	StateUnknown  JobState = iota // a state the client does not know
+	StateReady                    // waiting to be reserved
+	StateDelayed                  // waiting for its delay to pass before becoming ready
+	StateReserved                 // reserved by a client until its TTR runs out
+	StateBuried                   // set aside until kicked
+)
+
+var stateNames = [...]string{
+	StateUnknown:  "unknown",
+	StateReady:    "ready",
+	StateDelayed:  "delayed",
+	StateReserved: "reserved",
+	StateBuried:   "buried",
+}
+
+// Name of the state as the server reports it.
+func (s JobState) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return stateNames[StateUnknown]
+	}
+	return stateNames[s]
+}
+
+// Parse a state as the server reports it. Names the client does not know give StateUnknown.
+func ParseJobState(name string) JobState {
+	for s, n := range stateNames {
+		if n == name && JobState(s) != StateUnknown {
+			return JobState(s)
+		}
+	}
+	return StateUnknown
+}
+
+// Report whether the server leaves a job in this state until a client acts on it. Ready, delayed and reserved jobs move on by themselves as they are reserved or their time runs out; buried jobs stay until kicked.
+func (s JobState) IsTerminal() bool {
+	return s == StateBuried
+}
+
+// Encode the state as its name.
+func (s JobState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Decode a state from its name.
+func (s *JobState) UnmarshalText(text []byte) error {
+	*s = ParseJobState(string(text))
+	return nil
+}
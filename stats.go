@@ -1,466 +1,499 @@
 package beanpod
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// Deprecated: use the JobState constants.
 const (
-	S_READY    = "ready"
-	S_DELAYED  = "delayed"
-	S_RESERVED = "reserved"
-	S_BURIED   = "buried"
+	S_READY    = StateReady
+	S_DELAYED  = StateDelayed
+	S_RESERVED = StateReserved
+	S_BURIED   = StateBuried
 )
 
 // Statistical information about the system as a whole.
-type Stats struct {
-	m map[string]string
-}
+type ServerStats struct {
+	// Number of ready jobs with priority < 1024.
+	UrgentJobs int `beanstalk:"current-jobs-urgent,gauge"`
 
-// Number of ready jobs with priority < 1024.
-func (s *Stats) UrgentJobs() int {
-	n, _ := strconv.ParseUint(s.m["current-jobs-urgent "], 10, 64)
-	return int(n)
-}
+	// Number of jobs in the ready queue.
+	ReadyJobs int `beanstalk:"current-jobs-ready,gauge"`
 
-// Number of jobs in the ready queue.
-func (s *Stats) ReadyJobs() int {
-	n, _ := strconv.ParseUint(s.m["current-jobs-ready"], 10, 64)
-	return int(n)
-}
+	// Number of jobs reserved by all clients.
+	ReservedJobs int `beanstalk:"current-jobs-reserved,gauge"`
 
-// Number of jobs reserved by all clients.
-func (s *Stats) ReservedJobs() int {
-	n, _ := strconv.ParseUint(s.m["current-jobs-reserved"], 10, 64)
-	return int(n)
-}
+	// Number of delayed jobs.
+	DelayedJobs int `beanstalk:"current-jobs-delayed,gauge"`
 
-// Number of delayed jobs.
-func (s *Stats) DelayedJobs() int {
-	n, _ := strconv.ParseUint(s.m["current-jobs-delayed"], 10, 64)
-	return int(n)
-}
+	// Number of buried jobs.
+	BuriedJobs int `beanstalk:"current-jobs-buried,gauge"`
 
-// Number of buried jobs.
-func (s *Stats) BuriedJobs() int {
-	n, _ := strconv.ParseUint(s.m["current-jobs-buried"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of put commands.
+	PutCmds int `beanstalk:"cmd-put,counter"`
 
-// Cumulative number of put commands.
-func (s *Stats) PutCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-put"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of peek commands.
+	PeekCmds int `beanstalk:"cmd-peek,counter"`
 
-// Cumulative number of peek commands.
-func (s *Stats) PeekCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-peek"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of peek-ready commands.
+	PeekReadyCmds int `beanstalk:"cmd-peek-ready,counter"`
 
-// Cumulative number of peek-ready commands.
-func (s *Stats) PeekReadyCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-peek-ready"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of peek-delayed commands.
+	PeekDelayedCmds int `beanstalk:"cmd-peek-delayed,counter"`
 
-// Cumulative number of peek-delayed commands.
-func (s *Stats) PeekDelayedCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-peek-delayed"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of peek-buried commands.
+	PeekBuriedCmds int `beanstalk:"cmd-peek-buried,counter"`
 
-// Cumulative number of peek-buried commands.
-func (s *Stats) PeekBuriedCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-peek-buried"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of reserve commands.
+	ReserveCmds int `beanstalk:"cmd-reserve,counter"`
 
-// Cumulative number of reserve commands.
-func (s *Stats) ReserveCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-reserve"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of reserve-with-timeout commands.
+	ReserveWithTimeoutCmds int `beanstalk:"cmd-reserve-with-timeout,counter"`
 
-// Cumulative number of use commands.
-func (s *Stats) UseCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-use"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of use commands.
+	UseCmds int `beanstalk:"cmd-use,counter"`
 
-// Cumulative number of watch commands.
-func (s *Stats) WatchCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-watch"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of watch commands.
+	WatchCmds int `beanstalk:"cmd-watch,counter"`
 
-// Cumulative number of ignore commands.
-func (s *Stats) IgnoreCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-ignore"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of ignore commands.
+	IgnoreCmds int `beanstalk:"cmd-ignore,counter"`
 
-// Cumulative number of delete commands.
-func (s *Stats) DeleteCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-delete"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of delete commands.
+	DeleteCmds int `beanstalk:"cmd-delete,counter"`
 
-// Cumulative number of release commands.
-func (s *Stats) ReleaseCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-release"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of release commands.
+	ReleaseCmds int `beanstalk:"cmd-release,counter"`
 
-// Cumulative number of bury commands.
-func (s *Stats) BuryCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-bury"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of bury commands.
+	BuryCmds int `beanstalk:"cmd-bury,counter"`
 
-// Cumulative number of kick commands.
-func (s *Stats) KickCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-kick"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of kick commands.
+	KickCmds int `beanstalk:"cmd-kick,counter"`
 
-// Cumulative number of stats commands.
-func (s *Stats) StatsCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-stats"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of touch commands.
+	TouchCmds int `beanstalk:"cmd-touch,counter"`
 
-// Cumulative number of stats-job commands.
-func (s *Stats) StatsJobCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-stats-job"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of stats commands.
+	StatsCmds int `beanstalk:"cmd-stats,counter"`
 
-// Cumulative number of stats-tube commands.
-func (s *Stats) StatsTubeCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-stats-tube"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of stats-job commands.
+	StatsJobCmds int `beanstalk:"cmd-stats-job,counter"`
 
-// Cumulative number of list-tubes commands.
-func (s *Stats) ListTubesCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-list-tubes"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of stats-tube commands.
+	StatsTubeCmds int `beanstalk:"cmd-stats-tube,counter"`
 
-// Cumulative number of list-tube-used commands.
-func (s *Stats) ListTubeUsedCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-list-tube-used"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of list-tubes commands.
+	ListTubesCmds int `beanstalk:"cmd-list-tubes,counter"`
 
-// Cumulative number of list-tubes-watched commands.
-func (s *Stats) ListTubesWatchedCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-list-tubes-watched"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of list-tube-used commands.
+	ListTubeUsedCmds int `beanstalk:"cmd-list-tube-used,counter"`
 
-// Cumulative number of pause-tube commands
-func (s *Stats) PauseTubeCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-pause-tube"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of list-tubes-watched commands.
+	ListTubesWatchedCmds int `beanstalk:"cmd-list-tubes-watched,counter"`
 
-// Cumulative count of times a job has timed out.
-func (s *Stats) JobTimeouts() int {
-	n, _ := strconv.ParseUint(s.m["job-timeouts"], 10, 64)
-	return int(n)
-}
+	// Cumulative number of pause-tube commands
+	PauseTubeCmds int `beanstalk:"cmd-pause-tube,counter"`
 
-// Cumulative count of jobs created.
-func (s *Stats) TotalJobs() int {
-	n, _ := strconv.ParseUint(s.m["total-jobs"], 10, 64)
-	return int(n)
-}
+	// Cumulative count of times a job has timed out.
+	JobTimeouts int `beanstalk:"job-timeouts,counter"`
 
-// Maximum number of bytes in a job.
-func (s *Stats) MaxJobSize() int {
-	n, _ := strconv.ParseUint(s.m["max-job-size"], 10, 64)
-	return int(n)
-}
+	// Cumulative count of jobs created.
+	TotalJobs int `beanstalk:"total-jobs,counter"`
 
-// Number of currently-existing tubes.
-func (s *Stats) CurrentTubes() int {
-	n, _ := strconv.ParseUint(s.m["current-tubes"], 10, 64)
-	return int(n)
-}
+	// Maximum number of bytes in a job.
+	MaxJobSize int `beanstalk:"max-job-size"`
 
-// Number of currently open connections.
-func (s *Stats) CurrentConnections() int {
-	n, _ := strconv.ParseUint(s.m["current-connections"], 10, 64)
-	return int(n)
-}
+	// Number of currently-existing tubes.
+	CurrentTubes int `beanstalk:"current-tubes,gauge"`
 
-// Number of open connections that have each issued at least one put command.
-func (s *Stats) CurrentProducers() int {
-	n, _ := strconv.ParseUint(s.m["current-producers"], 10, 64)
-	return int(n)
-}
+	// Number of currently open connections.
+	CurrentConnections int `beanstalk:"current-connections,gauge"`
 
-// Number of open connections that have each issued at least one reserve command.
-func (s *Stats) CurrentWorkers() int {
-	n, _ := strconv.ParseUint(s.m["current-workers"], 10, 64)
-	return int(n)
-}
+	// Number of open connections that have each issued at least one put command.
+	CurrentProducers int `beanstalk:"current-producers,gauge"`
 
-// Number of open connections that have issued a reserve command but not yet received a response.
-func (s *Stats) CurrentWaiting() int {
-	n, _ := strconv.ParseUint(s.m["current-waiting"], 10, 64)
-	return int(n)
-}
+	// Number of open connections that have each issued at least one reserve command.
+	CurrentWorkers int `beanstalk:"current-workers,gauge"`
 
-//  Cumulative count of connections.
-func (s *Stats) TotalConnections() int {
-	n, _ := strconv.ParseUint(s.m["total-connections"], 10, 64)
-	return int(n)
-}
+	// Number of open connections that have issued a reserve command but not yet received a response.
+	CurrentWaiting int `beanstalk:"current-waiting,gauge"`
 
-// Process id of the server.
-func (s *Stats) PID() int {
-	n, _ := strconv.ParseUint(s.m["pid"], 10, 64)
-	return int(n)
-}
+	// Cumulative count of connections.
+	TotalConnections int `beanstalk:"total-connections,counter"`
 
-// Version string of the server.
-func (s *Stats) Version() string {
-	return s.m["version"]
-}
+	// Process id of the server.
+	PID int `beanstalk:"pid"`
 
-// Cumulative user CPU time of this process (accuracy: microseconds)
-func (s *Stats) RusageUtime() time.Duration {
-	t, _ := time.ParseDuration(s.m["rusage-utime"] + "s")
-	return t
-}
+	// Version string of the server.
+	Version string `beanstalk:"version"`
 
-// Cumulative system CPU time of the server process (accuracy: microseconds)
-func (s *Stats) RusageStime() time.Duration {
-	t, _ := time.ParseDuration(s.m["rusage-stime"] + "s")
-	return t
-}
+	// Cumulative user CPU time of this process (accuracy: microseconds)
+	RusageUtime time.Duration `beanstalk:"rusage-utime,counter"`
 
-// Time since this server process started running.
-func (s *Stats) Uptime() time.Duration {
-	t, _ := time.ParseDuration(s.m["uptime"] + "s")
-	return t
-}
+	// Cumulative system CPU time of the server process (accuracy: microseconds)
+	RusageStime time.Duration `beanstalk:"rusage-stime,counter"`
 
-// Index of the oldest binlog file needed to store the current jobs.
-func (s *Stats) BinlogOldestIndex() int {
-	n, _ := strconv.ParseUint(s.m["binlog-oldest-index"], 10, 64)
-	return int(n)
-}
+	// Time since this server process started running.
+	Uptime time.Duration `beanstalk:"uptime"`
 
-// Index of the current binlog file being written to. If binlog is not active this value will be 0.
-func (s *Stats) BinlogCurrentIndex() int {
-	n, _ := strconv.ParseUint(s.m["binlog-current-index"], 10, 64)
-	return int(n)
-}
+	// Index of the oldest binlog file needed to store the current jobs.
+	BinlogOldestIndex int `beanstalk:"binlog-oldest-index"`
 
-// Maximum size in bytes a binlog file is allowed to get before a new binlog file is opened.
-func (s *Stats) BinlogMaxSize() uint64 {
-	n, _ := strconv.ParseUint(s.m["binlog-max-size"], 10, 64)
-	return n
-}
+	// Index of the current binlog file being written to. If binlog is not active this value will be 0.
+	BinlogCurrentIndex int `beanstalk:"binlog-current-index"`
 
-// Cumulative number of records written to the binlog.
-func (s *Stats) BinlogRecordsWritten() int {
-	n, _ := strconv.ParseUint(s.m["binlog-records-written"], 10, 64)
-	return int(n)
-}
+	// Maximum size in bytes a binlog file is allowed to get before a new binlog file is opened.
+	BinlogMaxSize uint64 `beanstalk:"binlog-max-size"`
+
+	// Cumulative number of records written to the binlog.
+	BinlogRecordsWritten int `beanstalk:"binlog-records-written,counter"`
+
+	// Cumulative number of records written as part of compaction.
+	BinlogRecordsMigrated int `beanstalk:"binlog-records-migrated,counter"`
+
+	// A unique id for this server process. The id is generated on each startup and is always a random series of 8 bytes base16 encoded
+	ID string `beanstalk:"id,optional"`
+
+	// Hostname of the machine as determined by uname
+	Hostname string `beanstalk:"hostname,optional"`
+
+	// Whether the server is in drain mode, in which it refuses new jobs.
+	Draining bool `beanstalk:"draining,optional"`
 
-// Cumulative number of records written as part of compaction.
-func (s *Stats) BinlogRecordsMigrated() int {
-	n, _ := strconv.ParseUint(s.m["binlog-records-migrated"], 10, 64)
-	return int(n)
+	// Operating system the server runs on.
+	OS string `beanstalk:"os,optional"`
+
+	// Machine architecture the server runs on.
+	Platform string `beanstalk:"platform,optional"`
+
+	m map[string]string
 }
 
-// A unique id for this server process. The id is generated on each startup and is always a random series of 8 bytes base16 encoded
-func (s *Stats) ID() string {
-	return s.m["id"]
+// Response to the stats command as the server sent it, including keys the struct has no field for.
+func (s *ServerStats) Raw() map[string]string {
+	return s.m
 }
 
-// Hostname of the machine as determined by uname
-func (s *Stats) Hostname() string {
-	return s.m["hostname"]
+// Encode the stats as a JSON object keyed by the stats names, with durations in seconds. Keys without a field are included as strings.
+func (s *ServerStats) MarshalJSON() ([]byte, error) {
+	return marshalStats(s, s.m)
 }
 
+// Deprecated: Stats is the former name of ServerStats.
+type Stats = ServerStats
+
 // Statistical information about a tube.
 type TubeStats struct {
-	m map[string]string
-}
+	// Name of the tube.
+	Name string `beanstalk:"name"`
 
-// Name of the tube.
-func (s *TubeStats) Name() string {
-	return s.m["name"]
-}
+	// Number of ready jobs with priority < 1024 in this tube.
+	UrgentJobs int `beanstalk:"current-jobs-urgent,gauge"`
 
-// Number of ready jobs with priority < 1024 in this tube.
-func (s *TubeStats) UrgentJobs() int {
-	n, _ := strconv.ParseUint(s.m["current-jobs-urgent"], 10, 32)
-	return int(n)
-}
+	// Number of jobs in the ready queue in this tube.
+	ReadyJobs int `beanstalk:"current-jobs-ready,gauge"`
 
-// Number of jobs in the ready queue in this tube.
-func (s *TubeStats) ReadyJobs() int {
-	n, _ := strconv.ParseUint(s.m["current-jobs-ready"], 10, 32)
-	return int(n)
-}
+	// Number of jobs reserved by all clients in this tube.
+	ReservedJobs int `beanstalk:"current-jobs-reserved,gauge"`
 
-// Number of jobs reserved by all clients in this tube.
-func (s *TubeStats) ReservedJobs() int {
-	n, _ := strconv.ParseUint(s.m["current-jobs-reserved"], 10, 32)
-	return int(n)
-}
+	// Number of delayed jobs in this tube.
+	DelayedJobs int `beanstalk:"current-jobs-delayed,gauge"`
 
-// Number of delayed jobs in this tube.
-func (s *TubeStats) DelayedJobs() int {
-	n, _ := strconv.ParseUint(s.m["current-jobs-delayed"], 10, 32)
-	return int(n)
-}
+	// Number of buried jobs in this tube.
+	BuriedJobs int `beanstalk:"current-jobs-buried,gauge"`
 
-// Number of buried jobs in this tube.
-func (s *TubeStats) BuriedJobs() int {
-	n, _ := strconv.ParseUint(s.m["current-jobs-buried"], 10, 32)
-	return int(n)
-}
+	// Cumulative count of jobs created in this tube in the current beanstalkd process.
+	TotalJobs int `beanstalk:"total-jobs,counter"`
 
-// Cumulative count of jobs created in this tube in the current beanstalkd process.
-func (s *TubeStats) TotalJobs() int {
-	n, _ := strconv.ParseUint(s.m["total-jobs"], 10, 32)
-	return int(n)
-}
+	// Number of open connections that are currently using this tube.
+	Using int `beanstalk:"current-using,gauge"`
 
-// Number of open connections that are currently using this tube.
-func (s *TubeStats) Using() int {
-	n, _ := strconv.ParseUint(s.m["current-using"], 10, 32)
-	return int(n)
-}
+	// Number of open connections that have issued a reserve command while watching this tube but not yet received a response.
+	Waiting int `beanstalk:"current-waiting,gauge"`
 
-// Number of open connections that have issued a reserve command while watching this tube but not yet received a response.
-func (s *TubeStats) Waiting() int {
-	n, _ := strconv.ParseUint(s.m["current-waiting"], 10, 32)
-	return int(n)
-}
+	// Number of open connections that are currently watching this tube.
+	Watching int `beanstalk:"current-watching,gauge"`
 
-// Number of open connections that are currently watching this tube.
-func (s *TubeStats) Watching() int {
-	n, _ := strconv.ParseUint(s.m["current-watching"], 10, 32)
-	return int(n)
-}
+	// Time the tube has been paused for.
+	Pause time.Duration `beanstalk:"pause"`
 
-// Time the tube has been paused for.
-func (s *TubeStats) Pause() time.Duration {
-	t, _ := time.ParseDuration(s.m["pause"] + "s")
-	return t
-}
+	// Cumulative number of delete commands for this tube
+	DeleteCmds int `beanstalk:"cmd-delete,counter"`
+
+	// Cumulative number of pause-tube commands for this tube.
+	PauseTubeCmds int `beanstalk:"cmd-pause-tube,counter"`
+
+	// Period of time until the tube is un-paused.
+	PauseTimeLeft time.Duration `beanstalk:"pause-time-left"`
 
-// Cumulative number of delete commands for this tube
-func (s *TubeStats) DeleteCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-delete"], 10, 32)
-	return int(n)
+	m map[string]string
 }
 
-// Cumulative number of pause-tube commands for this tube.
-func (s *TubeStats) PauseTubeCmds() int {
-	n, _ := strconv.ParseUint(s.m["cmd-pause-tube"], 10, 32)
-	return int(n)
+// Response to the stats-tube command as the server sent it, including keys the struct has no field for.
+func (s *TubeStats) Raw() map[string]string {
+	return s.m
 }
 
-// Period of time until the tube is un-paused.
-func (s *TubeStats) PauseTimeLeft() time.Duration {
-	t, _ := time.ParseDuration(s.m["pause-time-left"] + "s")
-	return t
+// Encode the stats as a JSON object keyed by the stats names, with durations in seconds. Keys without a field are included as strings.
+func (s *TubeStats) MarshalJSON() ([]byte, error) {
+	return marshalStats(s, s.m)
 }
 
 // Statistical information about a job.
 type JobStats struct {
-	m map[string]string
-}
+	// Job ID.
+	ID JobID `beanstalk:"id"`
 
-// Job ID.
-func (s *JobStats) ID() JobID {
-	n, _ := strconv.ParseUint(s.m["id"], 10, 64)
-	return JobID(n)
-}
+	// Name of the tube that contains this job.
+	Tube string `beanstalk:"tube"`
 
-// Name of the tube that contains this job.
-func (s *JobStats) Tube() string {
-	return s.m["tube"]
-}
+	// Possible values: StateReady, StateDelayed, StateReserved, or StateBuried.
+	State JobState `beanstalk:"state"`
 
-// Possible values: ready, delayed, reserved, or buried.
-func (s *JobStats) State() string {
-	return s.m["state"]
-}
+	// Priority value set by the put, release, or bury commands.
+	Pri JobPriority `beanstalk:"pri"`
 
-// Priority value set by the put, release, or bury commands.
-func (s *JobStats) Pri() JobPriority {
-	n, _ := strconv.ParseUint(s.m["pri"], 10, 32)
-	return JobPriority(n)
-}
+	// Time since the put command that created this job.
+	Age time.Duration `beanstalk:"age"`
 
-// Time since the put command that created this job.
-func (s *JobStats) Age() time.Duration {
-	t, _ := time.ParseDuration(s.m["age"] + "s")
-	return t
-}
+	// Time left until the server puts this job into the ready queue. This number is only meaningful if the job is reserved or delayed. If the job is reserved and this amount of time elapses before its state changes, it is considered to have timed out.
+	TimeLeft time.Duration `beanstalk:"time-left"`
 
-// Time left until the server puts this job into the ready queue. This number is only meaningful if the job is reserved or delayed. If the job is reserved and this amount of time elapses before its state changes, it is considered to have timed out.
-func (s *JobStats) TimeLeft() time.Duration {
-	t, _ := time.ParseDuration(s.m["time-left"] + "s")
-	return t
-}
+	// Time that the job is delayed.
+	Delay time.Duration `beanstalk:"delay"`
 
-// Time that the job is delayed.
-func (s *JobStats) Delay() time.Duration {
-	t, _ := time.ParseDuration(s.m["delay"] + "s")
-	return t
-}
+	// Time to run.
+	TTR time.Duration `beanstalk:"ttr"`
 
-// Time to run.
-func (s *JobStats) TTR() time.Duration {
-	t, _ := time.ParseDuration(s.m["ttr"] + "s")
-	return t
-}
+	// Number of the earliest binlog file containing this job. If -b wasn't used, this will be 0.
+	File int `beanstalk:"file"`
 
-// Number of the earliest binlog file containing this job. If -b wasn't used, this will be 0.
-func (s *JobStats) File() int {
-	n, _ := strconv.ParseUint(s.m["file"], 10, 64)
-	return int(n)
-}
+	// Number of times this job has been reserved.
+	Reserves int `beanstalk:"reserves"`
 
-// Number of times this job has been reserved.
-func (s *JobStats) Reserves() int {
-	n, _ := strconv.ParseUint(s.m["reserves"], 10, 64)
-	return int(n)
-}
+	// Number of times this job has timed out during a reservation.
+	Timeouts int `beanstalk:"timeouts"`
 
-// Number of times this job has timed out during a reservation.
-func (s *JobStats) Timeouts() int {
-	n, _ := strconv.ParseUint(s.m["timeouts"], 10, 64)
-	return int(n)
-}
+	// Number of times a client has released this job from a reservation.
+	Releases int `beanstalk:"releases"`
 
-// Number of times a client has released this job from a reservation.
-func (s *JobStats) Releases() int {
-	n, _ := strconv.ParseUint(s.m["releases"], 10, 64)
-	return int(n)
-}
+	// Number of times this job has been buried.
+	Buries int `beanstalk:"buries"`
+
+	// Number of times this job has been kicked.
+	Kicks int `beanstalk:"kicks"`
 
-// Number of times this job has been buried.
-func (s *JobStats) Buries() int {
-	n, _ := strconv.ParseUint(s.m["buries"], 10, 64)
-	return int(n)
+	m map[string]string
 }
 
-// Number of times this job has been kicked.
-func (s *JobStats) Kicks() int {
-	n, _ := strconv.ParseUint(s.m["kicks"], 10, 64)
-	return int(n)
+// Response to the stats-job command as the server sent it, including keys the struct has no field for.
+func (s *JobStats) Raw() map[string]string {
+	return s.m
+}
+
+// Encode the stats as a JSON object keyed by the stats names, with durations in seconds. Keys without a field are included as strings.
+func (s *JobStats) MarshalJSON() ([]byte, error) {
+	return marshalStats(s, s.m)
+}
+
+// StatsError reports the keys of a stats response that could not be decoded. The stats are returned along with it, with zero values for these keys.
+type StatsError struct {
+	Missing   []string // keys the client expects but the server did not send
+	Malformed []string // keys whose values cannot be parsed
+	Unknown   []string // keys the client does not know, reported in strict mode only
+}
+
+func (e *StatsError) Error() string {
+	var parts []string
+	for _, p := range []struct {
+		what string
+		keys []string
+	}{{"missing", e.Missing}, {"malformed", e.Malformed}, {"unknown", e.Unknown}} {
+		if len(p.keys) > 0 {
+			parts = append(parts, p.what+" "+strings.Join(p.keys, ", "))
+		}
+	}
+	return "bad stats: " + strings.Join(parts, "; ")
+}
+
+// Report whether stats came back with the given keys decoded: err is nil, or a *StatsError about other keys only.
+func statsOK(err error, keys ...string) bool {
+	if err == nil {
+		return true
+	}
+	var e *StatsError
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, key := range keys {
+		for _, k := range append(e.Missing, e.Malformed...) {
+			if k == key {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	stateType    = reflect.TypeOf(JobState(0))
+)
+
+// Decode a stats dictionary into the fields of the struct v points to, each named by its beanstalk tag, as in
+//
+//	ReadyJobs int `beanstalk:"current-jobs-ready,gauge"`
+//	ID string `beanstalk:"id,optional"`
+//
+// Keys tagged optional may be missing. The counter and gauge options mark cumulative and current values for Diff. In strict mode, keys with no field are errors too.
+func decodeStats(m map[string]string, v interface{}, strict bool) error {
+	var e StatsError
+	rv := reflect.ValueOf(v).Elem()
+	rt := rv.Type()
+	known := make(map[string]bool, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		tag, ok := rt.Field(i).Tag.Lookup("beanstalk")
+		if !ok {
+			continue
+		}
+		key, opts, _ := strings.Cut(tag, ",")
+		known[key] = true
+		s, ok := m[key]
+		if !ok {
+			if !hasOption(opts, "optional") {
+				e.Missing = append(e.Missing, key)
+			}
+			continue
+		}
+		if err := setStat(rv.Field(i), s); err != nil {
+			e.Malformed = append(e.Malformed, key)
+		}
+	}
+	if strict {
+		for key := range m {
+			if !known[key] {
+				e.Unknown = append(e.Unknown, key)
+			}
+		}
+		sort.Strings(e.Unknown)
+	}
+	if e.Missing != nil || e.Malformed != nil || e.Unknown != nil {
+		return &e
+	}
+	return nil
+}
+
+// Report whether a comma-separated list of tag options contains opt.
+func hasOption(opts, opt string) bool {
+	for opts != "" {
+		var o string
+		o, opts, _ = strings.Cut(opts, ",")
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse a stats value into a field.
+func setStat(f reflect.Value, s string) error {
+	switch f.Type() {
+	case durationType:
+		// Durations are in seconds, possibly fractional
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(x * float64(time.Second)))
+		return nil
+	case stateType:
+		f.SetInt(int64(ParseJobState(s)))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported stats field type %s", f.Type())
+	}
+	return nil
+}
+
+// Encode the tagged fields of the struct v points to as a JSON object, followed by the keys of raw without a field.
+func marshalStats(v interface{}, raw map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	rv := reflect.ValueOf(v).Elem()
+	rt := rv.Type()
+	known := make(map[string]bool, rt.NumField())
+	add := func(key string, val interface{}) error {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		b, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(b)
+		return nil
+	}
+	for i := 0; i < rt.NumField(); i++ {
+		tag, ok := rt.Field(i).Tag.Lookup("beanstalk")
+		if !ok {
+			continue
+		}
+		key, _, _ := strings.Cut(tag, ",")
+		known[key] = true
+		val := rv.Field(i).Interface()
+		if d, ok := val.(time.Duration); ok {
+			val = d.Seconds()
+		}
+		if err := add(key, val); err != nil {
+			return nil, err
+		}
+	}
+	var extra []string
+	for key := range raw {
+		if !known[key] {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+	for _, key := range extra {
+		if err := add(key, raw[key]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
 }
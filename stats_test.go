@@ -0,0 +1,91 @@
+package beanpod
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeStats(t *testing.T) {
+	m := map[string]string{
+		"name":                  "jobs",
+		"current-jobs-urgent":   "3",
+		"current-jobs-ready":    "5",
+		"current-jobs-reserved": "x",
+		"current-jobs-delayed":  "0",
+		"current-jobs-buried":   "0",
+		"total-jobs":            "9",
+		"current-using":         "1",
+		"current-waiting":       "0",
+		"cmd-delete":            "4",
+		"cmd-pause-tube":        "0",
+		"pause":                 "1.5",
+		"pause-time-left":       "0",
+		"new-key":               "1",
+	}
+	var v TubeStats
+	err := decodeStats(m, &v, false)
+	var serr *StatsError
+	if !errors.As(err, &serr) {
+		t.Fatalf("err = %v, want *StatsError", err)
+	}
+	if !reflect.DeepEqual(serr.Missing, []string{"current-watching"}) || !reflect.DeepEqual(serr.Malformed, []string{"current-jobs-reserved"}) || serr.Unknown != nil {
+		t.Fatalf("err = %#v", serr)
+	}
+	if v.UrgentJobs != 3 || v.ReadyJobs != 5 || v.Pause != 1500*time.Millisecond || v.Name != "jobs" {
+		t.Fatalf("v = %+v", v)
+	}
+
+	m["current-jobs-reserved"] = "2"
+	m["current-watching"] = "1"
+	if err := decodeStats(m, &v, false); err != nil {
+		t.Fatal(err)
+	}
+	err = decodeStats(m, &v, true)
+	if !errors.As(err, &serr) || !reflect.DeepEqual(serr.Unknown, []string{"new-key"}) || serr.Missing != nil {
+		t.Fatalf("strict: err = %v", err)
+	}
+}
+
+func TestStatsJSON(t *testing.T) {
+	m := map[string]string{"id": "7", "tube": "jobs", "state": "buried", "pri": "10", "age": "2", "delay": "0", "ttr": "0.5", "time-left": "0", "file": "0", "reserves": "1", "timeouts": "0", "releases": "0", "buries": "1", "kicks": "0", "extra": "x"}
+	st := &JobStats{m: m}
+	if err := decodeStats(m, st, false); err != nil {
+		t.Fatal(err)
+	}
+	if st.Raw()["extra"] != "x" {
+		t.Fatalf("raw = %v", st.Raw())
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":7,"tube":"jobs","state":"buried","pri":10,"age":2,"time-left":0,"delay":0,"ttr":0.5,"file":0,"reserves":1,"timeouts":0,"releases":0,"buries":1,"kicks":0,"extra":"x"}`
+	if string(b) != want {
+		t.Fatalf("json = %s", b)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	prev := &ServerStats{ID: "a", Uptime: 10 * time.Second, PutCmds: 100, ReadyJobs: 5, RusageUtime: time.Second}
+	cur := &ServerStats{ID: "a", Uptime: 20 * time.Second, PutCmds: 150, ReadyJobs: 3, RusageUtime: 3 * time.Second}
+	d := Diff(prev, cur)
+	if d.Reset || d.Interval != 10*time.Second {
+		t.Fatalf("reset = %v, interval = %v", d.Reset, d.Interval)
+	}
+	if d.Rates["cmd-put"] != 5 || d.Rates["rusage-utime"] != 0.2 || d.Deltas["current-jobs-ready"] != -2 {
+		t.Fatalf("rates = %v, deltas = %v", d.Rates, d.Deltas)
+	}
+	if _, ok := d.Rates["uptime"]; ok {
+		t.Fatal("uptime is not a counter")
+	}
+
+	// The server restarted: counters start from zero
+	restarted := &ServerStats{ID: "b", Uptime: 4 * time.Second, PutCmds: 8, ReadyJobs: 1}
+	d = Diff(cur, restarted)
+	if !d.Reset || d.Interval != 4*time.Second || d.Rates["cmd-put"] != 2 || d.Deltas["current-jobs-ready"] != 1 {
+		t.Fatalf("after restart: %+v", d)
+	}
+}
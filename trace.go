@@ -0,0 +1,20 @@
+package beanpod
+
+import "context"
+
+// Propagator carries trace context from the producer of a job to its consumer through the job's envelope header, for a tracer to plug in.
+type Propagator interface {
+	// Write the trace context of ctx, such as the current span, into the header of a job being put.
+	Inject(ctx context.Context, h Header)
+
+	// Start a span for consuming a job, linked to the trace context found in its header, which is nil for jobs without an envelope. The returned context carries the span, and end finishes it with the outcome of handling the job.
+	StartConsumer(ctx context.Context, job *Job) (_ context.Context, end func(err error))
+}
+
+// Start a consumer span for the job with the propagator of the client the job is bound to. Without one, ctx is returned with an end function that does nothing. The worker calls it around the handler.
+func (j *Job) StartConsumer(ctx context.Context) (_ context.Context, end func(err error)) {
+	if j.c == nil || j.c.Propagator == nil {
+		return ctx, func(error) {}
+	}
+	return j.c.Propagator.StartConsumer(ctx, j)
+}
@@ -0,0 +1,234 @@
+package beanpod
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrWorkerClosed is returned by Worker.Run after Shutdown.
+var ErrWorkerClosed = errors.New("worker closed")
+
+// Function processing a reserved job. Returning nil deletes the job. Returning an error releases the job to be retried later, unless the error is marked with Permanent, in which case the job is buried.
+type Handler func(ctx context.Context, job *Job) error
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Mark an error returned by a Handler as permanent, so the job is buried instead of being retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// Report whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+// Consumer running a Handler on jobs reserved from a set of tubes.
+type Worker struct {
+	// Make a new client. Each goroutine of the worker has its own client, since jobs are tied to the connection that reserved them. Defaults to New with the worker address.
+	New func() *Client
+
+	// Tubes to reserve jobs from. Defaults to the default tube.
+	Tubes []string
+
+	// Function processing the jobs.
+	Handler Handler
+
+	// Middleware wrapping the handler, the first outermost. It is applied when Run starts.
+	Middleware []HandlerMiddleware
+
+	// Number of jobs processed at the same time. Defaults to 1.
+	Concurrency int
+
+	// Time a single reserve command waits for a job. Defaults to one minute.
+	ReserveTimeout time.Duration
+
+	// Fraction of a job's TTR after which the job is touched while the handler runs, keeping it reserved for as long as the handler needs. The handler's context is cancelled with cause ErrLeaseLost if a touch fails. Zero disables touching.
+	KeepAlive float64
+
+	// Delay of a released job by the number of times it has been released before. It is also used to wait after the connection failed. The server only supports whole seconds.
+	Backoff Backoff
+
+	// Logger for errors that cannot be returned to anyone. Defaults to the log package's standard logger.
+	ErrorLog *log.Logger
+
+	addr      string
+	mu        sync.Mutex
+	closed    bool
+	handler   Handler            // Handler wrapped in Middleware
+	stop      context.CancelFunc // stops reserving
+	interrupt context.CancelFunc // cancels running handlers
+	done      chan struct{}      // closed when Run returns
+}
+
+// Make a worker running h on jobs from tubes of a server address.
+func NewWorker(addr string, h Handler, tubes ...string) *Worker {
+	return &Worker{
+		addr:    addr,
+		Tubes:   tubes,
+		Handler: h,
+		Backoff: Backoff{
+			Min:    5 * time.Second,
+			Max:    10 * time.Minute,
+			Factor: 2,
+			Jitter: 0.2,
+		},
+	}
+}
+
+// Reserve and process jobs until Shutdown is called or ctx is done. Handlers get a context derived from ctx. Run returns ErrWorkerClosed after Shutdown, or ctx.Err() once all goroutines exited after ctx is done.
+func (w *Worker) Run(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed || w.done != nil {
+		w.mu.Unlock()
+		return ErrWorkerClosed
+	}
+	hctx, interrupt := context.WithCancel(ctx)
+	rctx, stop := context.WithCancel(hctx)
+	w.stop, w.interrupt = stop, interrupt
+	w.handler = ChainHandler(w.Middleware...)(w.Handler)
+	w.done = make(chan struct{})
+	w.mu.Unlock()
+
+	defer close(w.done)
+	defer interrupt()
+
+	n := w.Concurrency
+	if n < 1 {
+		n = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(rctx, hctx)
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrWorkerClosed
+}
+
+// Stop reserving jobs and wait for the jobs being processed to finish. If ctx is done first, the handlers' contexts are cancelled and ctx.Err() is returned.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	w.closed = true
+	stop, interrupt, done := w.stop, w.interrupt, w.done
+	w.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	stop()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		interrupt()
+		return ctx.Err()
+	}
+}
+
+func (w *Worker) newClient() *Client {
+	if w.New != nil {
+		return w.New()
+	}
+	return New(w.addr)
+}
+
+func (w *Worker) logf(format string, args ...interface{}) {
+	if w.ErrorLog != nil {
+		w.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// Reserve jobs until rctx is done, running the handler with hctx.
+func (w *Worker) loop(rctx, hctx context.Context) {
+	c := w.newClient()
+	defer c.Close()
+
+	timeout := w.ReserveTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	failures := 0
+	for rctx.Err() == nil {
+		job, err := c.ReserveContext(rctx, timeout, w.Tubes...)
+		switch {
+		case err == nil:
+			failures = 0
+			w.handle(hctx, c, job)
+		case errors.Is(err, ErrTimeout), errors.Is(err, ErrDeadline):
+			failures = 0
+		case rctx.Err() != nil:
+			return
+		default:
+			w.logf("beanpod: reserve: %v", err)
+			sleep(rctx, w.Backoff.Delay(failures))
+			failures++
+		}
+	}
+}
+
+// Run the handler on a job and delete, release or bury the job depending on the outcome.
+func (w *Worker) handle(ctx context.Context, c *Client, job *Job) {
+	if w.KeepAlive > 0 {
+		l, err := c.Lease(ctx, job.ID, w.KeepAlive)
+		if err != nil {
+			w.logf("beanpod: lease job %d: %v", job.ID, err)
+		} else {
+			defer l.Stop()
+			ctx = l.Context()
+		}
+	}
+
+	hctx, end := job.StartConsumer(ctx)
+	err := w.handler(hctx, job)
+	end(err)
+	if errors.Is(context.Cause(ctx), ErrLeaseLost) {
+		w.logf("beanpod: job %d: %v", job.ID, context.Cause(ctx))
+		return
+	}
+	if err == nil {
+		if err := c.Delete(job.ID); err != nil {
+			w.logf("beanpod: delete job %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	pri, releases := JobPriority(PRI_NORMAL), 0
+	if st, serr := c.StatsJob(job.ID); statsOK(serr, "pri", "releases") {
+		pri, releases = st.Pri, st.Releases
+	}
+	var perr *PanicError
+	if errors.As(err, &perr) {
+		w.logf("beanpod: job %d: %v\n%s", job.ID, perr, perr.Stack)
+	}
+	if IsPermanent(err) {
+		w.logf("beanpod: burying job %d: %v", job.ID, err)
+		if err := c.Bury(job.ID, pri); err != nil {
+			w.logf("beanpod: bury job %d: %v", job.ID, err)
+		}
+		return
+	}
+	if err := c.Release(job.ID, pri, w.Backoff.Delay(releases)); err != nil {
+		w.logf("beanpod: release job %d: %v", job.ID, err)
+	}
+}
@@ -0,0 +1,320 @@
+package beanpod_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/riobard/go-beanpod"
+)
+
+func TestWorker(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	for _, body := range []string{"ok", "ok", "fail", "poison"} {
+		if _, err := c.PutDefault("jobs", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var handled int32
+	w := beanpod.NewWorker(srv.Addr, func(ctx context.Context, job *beanpod.Job) error {
+		defer atomic.AddInt32(&handled, 1)
+		switch string(job.Body) {
+		case "fail":
+			return errors.New("try again")
+		case "poison":
+			return beanpod.Permanent(errors.New("cannot handle"))
+		}
+		return nil
+	}, "jobs")
+	w.Concurrency = 2
+	w.ReserveTimeout = time.Second
+
+	done := make(chan error)
+	go func() { done <- w.Run(context.Background()) }()
+	for atomic.LoadInt32(&handled) < 4 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != beanpod.ErrWorkerClosed {
+		t.Fatalf("Run = %v, want ErrWorkerClosed", err)
+	}
+
+	ts, err := c.StatsTube("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.DeleteCmds != 2 || ts.BuriedJobs != 1 || ts.DelayedJobs != 1 {
+		t.Fatalf("cmd-delete = %d, buried = %d, delayed = %d", ts.DeleteCmds, ts.BuriedJobs, ts.DelayedJobs)
+	}
+}
+
+func TestWorkerBackoff(t *testing.T) {
+	w := beanpod.NewWorker("", nil)
+	// The server truncates release delays to whole seconds, so the first retry must not come out as zero
+	for i := 0; i < 100; i++ {
+		if d := w.Backoff.Delay(0); d < time.Second {
+			t.Fatalf("Delay(0) = %v, want at least 1s", d)
+		}
+	}
+	if d := w.Backoff.Delay(20); d > 12*time.Minute {
+		t.Fatalf("Delay(20) = %v, want at most 12m", d)
+	}
+}
+
+func TestWorkerShutdownDrains(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	if _, err := c.PutDefault("jobs", []byte("slow")); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	w := beanpod.NewWorker(srv.Addr, func(ctx context.Context, job *beanpod.Job) error {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	}, "jobs")
+	go w.Run(context.Background())
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PeekReady("jobs"); err != beanpod.ErrNotFound {
+		t.Fatalf("job not deleted: %v", err)
+	}
+}
+
+type traceKey struct{}
+
+// Propagates a trace id through the Trace-Id header and records the spans it starts.
+type testPropagator struct {
+	mu    sync.Mutex
+	spans []string // parent trace ids of consumer spans, with their outcome
+}
+
+func (p *testPropagator) Inject(ctx context.Context, h beanpod.Header) {
+	if id, ok := ctx.Value(traceKey{}).(string); ok {
+		h.Set("Trace-Id", id)
+	}
+}
+
+func (p *testPropagator) StartConsumer(ctx context.Context, job *beanpod.Job) (context.Context, func(error)) {
+	parent := job.Header.Get("Trace-Id")
+	return context.WithValue(ctx, traceKey{}, parent+"/consumer"), func(err error) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.spans = append(p.spans, fmt.Sprintf("%s %v", parent, err))
+	}
+}
+
+func TestWorkerPropagator(t *testing.T) {
+	srv, _ := fakeServer(t)
+	p := &testPropagator{}
+	c := newClient(t, srv)
+	c.Envelope = true
+	c.Propagator = p
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-1")
+	if _, err := c.PutContext(ctx, "jobs", []byte("x"), 0, 0, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan string, 1)
+	w := beanpod.NewWorker(srv.Addr, func(ctx context.Context, job *beanpod.Job) error {
+		got <- ctx.Value(traceKey{}).(string)
+		return nil
+	}, "jobs")
+	w.New = func() *beanpod.Client {
+		c := beanpod.New(srv.Addr)
+		c.Propagator = p
+		return c
+	}
+	go w.Run(context.Background())
+	if id := <-got; id != "trace-1/consumer" {
+		t.Fatalf("handler trace = %q", id)
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Shutdown(sctx); err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.spans) != 1 || p.spans[0] != "trace-1 <nil>" {
+		t.Fatalf("spans = %q", p.spans)
+	}
+}
+
+func TestWorkerMiddleware(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	if _, err := c.PutDefault("jobs", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var trail []string
+	mark := func(name string) beanpod.HandlerMiddleware {
+		return func(next beanpod.Handler) beanpod.Handler {
+			return func(ctx context.Context, job *beanpod.Job) error {
+				mu.Lock()
+				trail = append(trail, name)
+				mu.Unlock()
+				return next(ctx, job)
+			}
+		}
+	}
+	done := make(chan struct{})
+	w := beanpod.NewWorker(srv.Addr, func(ctx context.Context, job *beanpod.Job) error {
+		close(done)
+		return nil
+	}, "jobs")
+	w.Middleware = []beanpod.HandlerMiddleware{mark("outer"), mark("inner")}
+	go w.Run(context.Background())
+	<-done
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(trail) != 2 || trail[0] != "outer" || trail[1] != "inner" {
+		t.Fatalf("trail = %v", trail)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	if _, err := c.PutDefault("jobs", []byte("poison")); err != nil {
+		t.Fatal(err)
+	}
+	h := beanpod.Recover(2)(func(ctx context.Context, job *beanpod.Job) error {
+		panic("boom")
+	})
+
+	for i := 1; i <= 2; i++ {
+		job, err := c.Reserve(0, "jobs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = h(context.Background(), job)
+		var perr *beanpod.PanicError
+		switch i {
+		case 1:
+			if !errors.As(err, &perr) || perr.Value != "boom" || len(perr.Stack) == 0 || beanpod.IsPermanent(err) {
+				t.Fatalf("reserve %d: err = %v", i, err)
+			}
+		case 2:
+			if !errors.As(err, &perr) || !beanpod.IsPermanent(err) {
+				t.Fatalf("reserve %d: err = %v", i, err)
+			}
+		}
+		if err := job.Release(beanpod.PRI_HIGH, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Jobs that do not panic go through whatever their count
+	ok := beanpod.Recover(2)(func(ctx context.Context, job *beanpod.Job) error {
+		return nil
+	})
+	job, err := c.Reserve(0, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ok(context.Background(), job); err != nil {
+		t.Fatalf("reserve 3: err = %v", err)
+	}
+}
+
+func TestRecoverCancelled(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	if _, err := c.PutDefault("jobs", []byte("poison")); err != nil {
+		t.Fatal(err)
+	}
+	job, err := c.Reserve(0, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := beanpod.Recover(5)(func(ctx context.Context, job *beanpod.Job) error {
+		panic("boom")
+	})
+	// The worker cancels handler contexts on shutdown, and the job must stay reserved for it to be released or buried
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := h(ctx, job); beanpod.IsPermanent(err) {
+		t.Fatalf("err = %v, want a retry after 1 reserve", err)
+	}
+	if st, err := job.Stats(); err != nil || st.State != beanpod.StateReserved {
+		t.Fatalf("stats-job = %v, %v", st, err)
+	}
+}
+
+func TestWorkerRecover(t *testing.T) {
+	srv, _ := fakeServer(t)
+	c := newClient(t, srv)
+	id, err := c.PutDefault("jobs", []byte("poison"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	w := beanpod.NewWorker(srv.Addr, func(ctx context.Context, job *beanpod.Job) error {
+		panic("boom")
+	}, "jobs")
+	w.Middleware = []beanpod.HandlerMiddleware{beanpod.Recover(0)}
+	w.ErrorLog = log.New(writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.Write(p)
+	}), "", 0)
+	go w.Run(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, err := c.StatsJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.State == beanpod.StateBuried {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("state = %v", st.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if out := buf.String(); !strings.Contains(out, "panic: boom") || !strings.Contains(out, "goroutine") {
+		t.Fatalf("log = %s", out)
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }